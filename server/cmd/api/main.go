@@ -1,7 +1,9 @@
 package main
 
 import (
+	"foundry-server/internal/config"
 	"foundry-server/internal/database"
+	"foundry-server/internal/dns"
 	"foundry-server/internal/handler"
 	"log"
 
@@ -19,6 +21,11 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// Load installation config (domains, ingress)
+	if err := config.Load(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Init Database
 	database.InitDB() // Changed from Connect() to InitDB()
 
@@ -37,11 +44,13 @@ func main() {
 
 	// Public Routes
 	handler.InitOAuth()
+	dns.InitResolver()
 	// Auth routes moved to /api/auth/...
 	e.GET("/api/auth/login", handler.GithubLogin)             // Entry point
 	e.GET("/api/auth/github/login", handler.GithubLogin)      // Alias
 	e.GET("/api/auth/github/callback", handler.GithubCallback)
 	e.GET("/api/projects/public", handler.GetPublicProjects)
+	e.POST("/api/webhooks/github", handler.GithubWebhook) // Verified by its signature
 
 	// Protected Routes
 	api := e.Group("/api")
@@ -50,6 +59,7 @@ func main() {
 	api.POST("/activate", handler.ActivateAccount)
 	api.GET("/me", handler.GetMe)
 	api.DELETE("/me", handler.DeleteAccount)
+	api.GET("/me/quota", handler.GetMyQuota)
 	
 	api.GET("/my/projects", handler.GetMyProjects)
 	api.POST("/projects", handler.CreateProject)
@@ -57,17 +67,68 @@ func main() {
 	api.GET("/projects/:id", handler.GetProject)
 	api.GET("/projects/:id/stats", handler.GetProjectStats)
 	api.GET("/projects/:id/logs", handler.GetProjectLogs)
+	api.GET("/projects/:id/builds", handler.GetProjectBuilds)
+	api.POST("/projects/:id/builds/:buildId/cancel", handler.CancelProjectBuild)
+	api.POST("/projects/:id/deploy-key", handler.RotateDeployKey)
+	api.GET("/projects/:id/previews", handler.GetProjectPreviews)
+	api.GET("/projects/:id/stages", handler.GetProjectStages)
+	api.POST("/projects/:id/stages", handler.CreateProjectStage)
+	api.PATCH("/projects/:id/stages/:stageId", handler.UpdateProjectStage)
+	api.DELETE("/projects/:id/stages/:stageId", handler.DeleteProjectStage)
+	api.POST("/projects/:id/stages/:stageId/deploy", handler.DeployProjectStage)
+	api.POST("/projects/:id/stages/:stageId/promote", handler.PromoteProjectStage)
+	api.GET("/projects/:id/releases", handler.GetProjectReleases)
+	api.GET("/projects/:id/rollouts", handler.GetProjectRollouts)
+	api.GET("/projects/:id/processes", handler.GetProjectProcesses)
+	api.PUT("/projects/:id/processes", handler.SetProjectProcesses)
+	api.PATCH("/projects/:id/processes/:name", handler.UpdateProjectProcess)
+	api.DELETE("/projects/:id/processes/:name", handler.DeleteProjectProcess)
+	api.GET("/projects/:id/processes/:name/logs", handler.GetProjectProcessLogs)
+	api.GET("/projects/:id/volumes", handler.GetProjectVolumes)
+	api.POST("/projects/:id/volumes", handler.CreateProjectVolume)
+	api.PATCH("/projects/:id/volumes/:name", handler.UpdateProjectVolume)
 	api.PATCH("/projects/:id", handler.UpdateProject)
 	api.DELETE("/projects/:id", handler.DeleteProject)
+	api.GET("/projects/:id/env/versions", handler.GetProjectEnvVersions)
+	api.GET("/projects/:id/env/diff", handler.DiffProjectEnvVersions)
+	api.POST("/projects/:id/env/versions/:version/restore", handler.RestoreProjectEnvVersion)
+	api.GET("/projects/:id/domains", handler.GetProjectDomains)
+	api.POST("/projects/:id/domains", handler.AddProjectDomain)
+	api.POST("/projects/:id/domains/:domainId/verify", handler.VerifyProjectDomain)
+	api.DELETE("/projects/:id/domains/:domainId", handler.DeleteProjectDomain)
+
+	// GitHub repository picker
+	api.GET("/github/repos", handler.GetGithubRepos)
+	api.GET("/github/repos/:owner/:repo/branches", handler.GetGithubBranches)
+
+	// Registries
+	api.GET("/registries", handler.GetRegistries)
+	api.POST("/registries", handler.CreateRegistry)
+	api.PUT("/registries/:id", handler.UpdateRegistry)
+	api.DELETE("/registries/:id", handler.DeleteRegistry)
 
     // Environments
     api.GET("/environments", handler.GetEnvironments)
     api.POST("/environments", handler.CreateEnvironment)
     api.DELETE("/environments/:id", handler.DeleteEnvironment)
+    api.GET("/environments/:id/versions", handler.GetEnvironmentVersions)
+    api.GET("/environments/:id/diff", handler.DiffEnvironmentVersions)
+    api.POST("/environments/:id/versions/:version/restore", handler.RestoreEnvironmentVersion)
 	
 	api.POST("/projects/:id/like", handler.ToggleLike)
 	api.POST("/projects/:id/favorite", handler.ToggleFavorite)
 	api.POST("/projects/:id/view", handler.RegisterView)
 
+	// Admin
+	admin := api.Group("/admin", handler.AdminMiddleware)
+	admin.GET("/users/:id/quota", handler.GetUserQuota)
+	admin.PUT("/users/:id/quota", handler.SetUserQuota)
+
+	// Background Jobs
+	handler.StartSlugRedirectCleanup()
+	handler.StartBuildQueue()
+	handler.StartImageWatcher()
+	handler.StartRolloutController()
+
 	e.Logger.Fatal(e.Start(":8080")) // Frontend is 5173, Server 8080
 }
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Config holds installation-specific settings for how deployed apps are exposed
+type Config struct {
+	BaseDomain       string // apps are served under this domain, e.g. heejunp.com
+	HostSuffix       string // appended to the app name in its subdomain, e.g. "-foundry"
+	IngressClass     string
+	IngressNamespace string // where the ingress controller runs; allowed to reach tenant apps
+	ClusterIssuer    string // cert-manager ClusterIssuer used for app certificates
+
+	SlugRedirectGrace time.Duration // how long a renamed project's old subdomain keeps redirecting
+
+	// Tenancy: every user gets their own namespace, capped by these defaults.
+	// Admins can override the quotas per user.
+	NamespacePrefix  string
+	UserCPUQuota     string // total CPU requests across a user's projects
+	UserMemoryQuota  string // total memory requests across a user's projects
+	UserStorageQuota string // total size of a user's persistent volumes
+	UserMaxReplicas  int    // replicas a single project may scale to
+	UserMaxProjects  int
+	UserMaxBuilds    int // concurrent builds per user
+
+	AdminUsers []string // numeric GitHub account IDs allowed to manage quotas; logins can be renamed
+
+	// Persistent volumes are provisioned from this StorageClass (empty: the cluster default)
+	VolumeStorageClass string
+
+	// Builds
+	MaxConcurrentBuilds int // builds running at once across all users
+	DefaultBuildTimeout int // minutes
+	MaxBuildTimeout     int // minutes; ceiling for the per-project timeout
+	BuildCPURequest     string
+	BuildCPULimit       string
+	BuildMemoryRequest  string
+	BuildMemoryLimit    string
+
+	// Pull request previews: GitHub delivers pull_request events to GithubWebhookURL
+	// (the public address of /api/webhooks/github), signed with GithubWebhookSecret
+	GithubWebhookURL    string
+	GithubWebhookSecret string
+	MaxPreviews         int // open previews per project; each counts against the owner's quota
+
+	// Image projects with auto-redeploy check their tag for a new digest this often
+	ImagePollInterval time.Duration
+
+	// Blue/green and canary rollouts: a new version has RolloutTimeout to become ready.
+	// With PrometheusURL set, its 5xx rate is read from the ingress controller's metrics
+	// using ErrorRateQuery ({namespace} and {service} are filled in); without it, only
+	// readiness and restarts are checked.
+	RolloutTimeout time.Duration
+	PrometheusURL  string
+	ErrorRateQuery string
+
+	// Networking: app egress to these ranges (cluster network, metadata service) is blocked
+	EgressBlockedCIDRs []string
+}
+
+// App is the active configuration, populated by Load
+var App = Config{
+	BaseDomain:       "heejunp.com",
+	HostSuffix:       "-foundry",
+	IngressClass:     "nginx",
+	IngressNamespace: "ingress-nginx",
+	ClusterIssuer:    "letsencrypt-prod",
+
+	SlugRedirectGrace: 30 * 24 * time.Hour,
+
+	NamespacePrefix:  "foundry-user-",
+	UserCPUQuota:     "2",
+	UserMemoryQuota:  "2Gi",
+	UserStorageQuota: "10Gi",
+	UserMaxReplicas:  3,
+	UserMaxProjects:  5,
+	UserMaxBuilds:    1,
+
+	MaxConcurrentBuilds: 3,
+	DefaultBuildTimeout: 20,
+	MaxBuildTimeout:     60,
+	BuildCPURequest:     "250m",
+	BuildCPULimit:       "1",
+	BuildMemoryRequest:  "512Mi",
+	BuildMemoryLimit:    "2Gi",
+
+	MaxPreviews: 3,
+
+	ImagePollInterval: 5 * time.Minute,
+
+	RolloutTimeout: 10 * time.Minute,
+	ErrorRateQuery: `sum(rate(nginx_ingress_controller_requests{exported_namespace="{namespace}",exported_service="{service}",status=~"5.."}[2m]))` +
+		` / sum(rate(nginx_ingress_controller_requests{exported_namespace="{namespace}",exported_service="{service}"}[2m]))`,
+
+	EgressBlockedCIDRs: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16"},
+}
+
+// Load reads the configuration from environment variables, keeping defaults for unset values.
+// It fails on values that would otherwise only break once they are used.
+func Load() error {
+	App.BaseDomain = getEnv("APPS_BASE_DOMAIN", App.BaseDomain)
+	App.HostSuffix = getEnv("APPS_HOST_SUFFIX", App.HostSuffix)
+	App.IngressClass = getEnv("INGRESS_CLASS", App.IngressClass)
+	App.IngressNamespace = getEnv("INGRESS_NAMESPACE", App.IngressNamespace)
+	App.ClusterIssuer = getEnv("CLUSTER_ISSUER", App.ClusterIssuer)
+	if days, err := strconv.Atoi(os.Getenv("SLUG_REDIRECT_DAYS")); err == nil && days >= 0 {
+		App.SlugRedirectGrace = time.Duration(days) * 24 * time.Hour
+	}
+
+	App.NamespacePrefix = getEnv("USER_NAMESPACE_PREFIX", App.NamespacePrefix)
+	App.UserCPUQuota = getEnv("USER_CPU_QUOTA", App.UserCPUQuota)
+	App.UserMemoryQuota = getEnv("USER_MEMORY_QUOTA", App.UserMemoryQuota)
+	App.UserStorageQuota = getEnv("USER_STORAGE_QUOTA", App.UserStorageQuota)
+	App.VolumeStorageClass = getEnv("VOLUME_STORAGE_CLASS", App.VolumeStorageClass)
+	if n, err := strconv.Atoi(os.Getenv("USER_MAX_REPLICAS")); err == nil && n > 0 {
+		App.UserMaxReplicas = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("USER_MAX_PROJECTS")); err == nil && n > 0 {
+		App.UserMaxProjects = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("USER_MAX_BUILDS")); err == nil && n > 0 {
+		App.UserMaxBuilds = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_BUILDS")); err == nil && n > 0 {
+		App.MaxConcurrentBuilds = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DEFAULT_BUILD_TIMEOUT_MINUTES")); err == nil && n > 0 {
+		App.DefaultBuildTimeout = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_BUILD_TIMEOUT_MINUTES")); err == nil && n > 0 {
+		App.MaxBuildTimeout = n
+	}
+	if App.DefaultBuildTimeout > App.MaxBuildTimeout {
+		App.DefaultBuildTimeout = App.MaxBuildTimeout
+	}
+	App.BuildCPURequest = getEnv("BUILD_CPU_REQUEST", App.BuildCPURequest)
+	App.BuildCPULimit = getEnv("BUILD_CPU_LIMIT", App.BuildCPULimit)
+	App.BuildMemoryRequest = getEnv("BUILD_MEMORY_REQUEST", App.BuildMemoryRequest)
+	App.BuildMemoryLimit = getEnv("BUILD_MEMORY_LIMIT", App.BuildMemoryLimit)
+	App.GithubWebhookURL = getEnv("GITHUB_WEBHOOK_URL", App.GithubWebhookURL)
+	App.GithubWebhookSecret = getEnv("GITHUB_WEBHOOK_SECRET", App.GithubWebhookSecret)
+	if n, err := strconv.Atoi(os.Getenv("MAX_PREVIEWS_PER_PROJECT")); err == nil && n > 0 {
+		App.MaxPreviews = n
+	}
+	if minutes, err := strconv.Atoi(os.Getenv("IMAGE_POLL_MINUTES")); err == nil && minutes > 0 {
+		App.ImagePollInterval = time.Duration(minutes) * time.Minute
+	}
+	if minutes, err := strconv.Atoi(os.Getenv("ROLLOUT_TIMEOUT_MINUTES")); err == nil && minutes > 0 {
+		App.RolloutTimeout = time.Duration(minutes) * time.Minute
+	}
+	App.PrometheusURL = strings.TrimSuffix(getEnv("PROMETHEUS_URL", App.PrometheusURL), "/")
+	App.ErrorRateQuery = getEnv("ROLLOUT_ERROR_RATE_QUERY", App.ErrorRateQuery)
+	if admins := os.Getenv("ADMIN_USERS"); admins != "" {
+		App.AdminUsers = strings.Fields(strings.ReplaceAll(admins, ",", " "))
+	}
+	if cidrs := os.Getenv("EGRESS_BLOCKED_CIDRS"); cidrs != "" {
+		App.EgressBlockedCIDRs = strings.Fields(strings.ReplaceAll(cidrs, ",", " "))
+	}
+
+	if err := validateQuantities(); err != nil {
+		return err
+	}
+
+	fmt.Printf("[Config] Apps served at *%s.%s (ingress class: %s, issuer: %s)\n",
+		App.HostSuffix, App.BaseDomain, App.IngressClass, App.ClusterIssuer)
+	return nil
+}
+
+// validateQuantities checks the resource quantities, which are parsed with resource.MustParse
+func validateQuantities() error {
+	quantities := []struct {
+		key, value string
+	}{
+		{"USER_CPU_QUOTA", App.UserCPUQuota},
+		{"USER_MEMORY_QUOTA", App.UserMemoryQuota},
+		{"USER_STORAGE_QUOTA", App.UserStorageQuota},
+		{"BUILD_CPU_REQUEST", App.BuildCPURequest},
+		{"BUILD_CPU_LIMIT", App.BuildCPULimit},
+		{"BUILD_MEMORY_REQUEST", App.BuildMemoryRequest},
+		{"BUILD_MEMORY_LIMIT", App.BuildMemoryLimit},
+	}
+	parsed := map[string]resource.Quantity{}
+	for _, q := range quantities {
+		value, err := resource.ParseQuantity(q.value)
+		if err != nil || value.Sign() <= 0 {
+			return fmt.Errorf("%s must be a positive quantity, got %q", q.key, q.value)
+		}
+		parsed[q.key] = value
+	}
+	for _, pair := range [][2]string{{"BUILD_CPU_REQUEST", "BUILD_CPU_LIMIT"}, {"BUILD_MEMORY_REQUEST", "BUILD_MEMORY_LIMIT"}} {
+		request, limit := parsed[pair[0]], parsed[pair[1]]
+		if request.Cmp(limit) > 0 {
+			return fmt.Errorf("%s must not exceed %s", pair[0], pair[1])
+		}
+	}
+	return nil
+}
+
+// AppHost returns the default public hostname for an app
+func (c Config) AppHost(name string) string {
+	return fmt.Sprintf("%s%s.%s", name, c.HostSuffix, c.BaseDomain)
+}
+
+// IsAdmin reports whether the GitHub account ID belongs to an administrator
+func (c Config) IsAdmin(githubID string) bool {
+	for _, a := range c.AdminUsers {
+		if a == githubID {
+			return true
+		}
+	}
+	return false
+}
+
+func getEnv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// GenerateDeployKey creates an Ed25519 SSH key pair. It returns the private key in
+// OpenSSH format and the public key as an authorized_keys line ending in comment.
+func GenerateDeployKey(comment string) (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %v", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))
+	if comment != "" {
+		authorizedKey += " " + comment
+	}
+	return string(pem.EncodeToMemory(block)), authorizedKey, nil
+}
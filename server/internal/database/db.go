@@ -36,7 +36,47 @@ func InitDB() {
 	DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	// 2. AutoMigrate to sync schema
-	if err := DB.AutoMigrate(&model.User{}, &model.Project{}, &model.ProjectEnv{}, &model.Environment{}, &model.EnvironmentVar{}); err != nil {
+	if err := DB.AutoMigrate(
+		&model.User{},
+		&model.Project{},
+		&model.ProjectEnv{},
+		&model.Environment{},
+		&model.EnvironmentVar{},
+		&model.EnvVersion{},
+		&model.EnvVersionVar{},
+		&model.Domain{},
+		&model.SlugRedirect{},
+		&model.UserQuota{},
+		&model.Build{},
+		&model.Registry{},
+		&model.GitCredential{},
+		&model.Preview{},
+		&model.Stage{},
+		&model.StageEnv{},
+		&model.Release{},
+		&model.Rollout{},
+		&model.Process{},
+		&model.Volume{},
+	); err != nil {
 		log.Printf("Failed to migrate database: %v", err)
 	}
+
+	// 3. Projects created before sizing ran with a fixed 1 CPU / 1Gi (requests and limits).
+	// They keep it as a custom size instead of shrinking to the default preset on their next deploy.
+	if err := DB.Model(&model.Project{}).Where("cpu_request IS NULL OR cpu_request = ''").Updates(map[string]interface{}{
+		"size":           "custom",
+		"cpu_request":    "1",
+		"cpu_limit":      "1",
+		"memory_request": "1Gi",
+		"memory_limit":   "1Gi",
+	}).Error; err != nil {
+		log.Printf("Failed to backfill project sizes: %v", err)
+	}
+
+	// 4. A custom domain used to be unique even while unverified; only verified hosts are now
+	if DB.Migrator().HasIndex(&model.Domain{}, "idx_domains_host") {
+		if err := DB.Migrator().DropIndex(&model.Domain{}, "idx_domains_host"); err != nil {
+			log.Printf("Failed to drop index idx_domains_host: %v", err)
+		}
+	}
 }
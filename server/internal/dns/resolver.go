@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Resolver looks up TXT records for domain ownership verification.
+// It is swappable so verification can be exercised locally without public DNS.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Default is the resolver used by the API handlers
+var Default Resolver = net.DefaultResolver
+
+// InitResolver configures Default from the environment.
+// DNS_RESOLVER=host:port sends lookups to a specific server (e.g. a local CoreDNS);
+// when unset the system resolver is used.
+func InitResolver() {
+	addr := os.Getenv("DNS_RESOLVER")
+	if addr == "" {
+		return
+	}
+
+	Default = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	fmt.Printf("[DNS] Using resolver %s\n", addr)
+}
+
+// StaticResolver answers TXT lookups from a fixed map. Useful for local development and tests.
+type StaticResolver map[string][]string
+
+func (r StaticResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if records, ok := r[name]; ok {
+		return records, nil
+	}
+	return nil, fmt.Errorf("no TXT records for %s", name)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"foundry-server/internal/config"
 	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
 	"foundry-server/internal/model"
 	"net/http"
 	"os"
@@ -38,7 +40,9 @@ func InitOAuth() {
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		RedirectURL:  redirectURL,
-		Scopes:       []string{"read:user"},
+		// repo: list and clone the user's private repositories too.
+		// Users who logged in before only see public repositories until they log in again.
+		Scopes:       []string{"read:user", "repo"},
 		Endpoint:     github.Endpoint,
 	}
 }
@@ -59,6 +63,22 @@ func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// AdminMiddleware restricts a route to the users listed in ADMIN_USERS.
+// It must run after AuthMiddleware.
+func AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var user model.User
+		if err := database.DB.Select("github_id").Where("id = ?", userID).First(&user).Error; err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		}
+		if !config.App.IsAdmin(user.GithubID) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin access required"})
+		}
+		return next(c)
+	}
+}
+
 // GithubLogin redirects user to GitHub
 func GithubLogin(c echo.Context) error {
 	url := oauthConfig.AuthCodeURL("foundry-state", oauth2.AccessTypeOnline)
@@ -142,6 +162,7 @@ func GetMe(c echo.Context) error {
 	if result := database.DB.First(&user, "id = ?", userID); result.Error != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
+	user.IsAdmin = config.App.IsAdmin(user.GithubID)
 	return c.JSON(http.StatusOK, user)
 }
 
@@ -190,6 +211,13 @@ func ActivateAccount(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to activate"})
 	}
 
+	// Provision the user's namespace up front (deploys also ensure it exists)
+	if k8s.Client != nil {
+		if _, err := k8s.EnsureUserNamespace(userID); err != nil {
+			c.Logger().Errorf("Failed to create namespace for user %s: %v", userID, err)
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]string{"message": "Account activated"})
 }
 
@@ -226,7 +254,14 @@ func DeleteAccount(c echo.Context) error {
 		}
 	}
 
-	// 2. Delete User from DB
+	// 2. Delete the user's namespace (removes every app, secret and build in it)
+	if k8s.Client != nil {
+		if err := k8s.DeleteUserNamespace(userID); err != nil {
+			fmt.Printf("Failed to delete namespace for user %s: %v\n", userID, err)
+		}
+	}
+
+	// 3. Delete User from DB
 	// DB logic: cascading delete on projects is configured in schema if we used ON DELETE CASCADE
 	// But GORM requires explicit setup or manual delete.
 	// Our schema SQL says: owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE
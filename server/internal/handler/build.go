@@ -0,0 +1,337 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"foundry-server/internal/quota"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// buildTimeoutGrace is how long after its deadline a build is given up on by the queue.
+// Kubernetes enforces the deadline itself; this covers Jobs that vanished or got stuck.
+const buildTimeoutGrace = 2 * time.Minute
+
+// buildQueueKick wakes the dispatcher up early, e.g. right after a build was queued
+var buildQueueKick = make(chan struct{}, 1)
+
+func kickBuildQueue() {
+	select {
+	case buildQueueKick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueBuild queues a build of the project. The dispatcher starts it once a slot is free.
+func enqueueBuild(project *model.Project, branch string) (*model.Build, error) {
+	if project.SourceType == "image" {
+		return nil, fmt.Errorf("image projects are not built")
+	}
+	if err := k8s.ValidateBranch(branch); err != nil {
+		return nil, err
+	}
+	build := model.Build{
+		ProjectID: project.ID,
+		Branch:    branch,
+		Status:    "queued",
+	}
+	if err := database.DB.Create(&build).Error; err != nil {
+		return nil, err
+	}
+	database.DB.Model(project).Update("status", "building")
+	kickBuildQueue()
+	return &build, nil
+}
+
+// StartBuildQueue runs the dispatcher: it tracks running builds, deploys finished ones
+// and starts queued builds within the global and per-user concurrency limits.
+// The queue lives in the database, so builds survive restarts of the API.
+func StartBuildQueue() {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			if database.DB != nil && k8s.Client != nil {
+				pollRunningBuilds()
+				dispatchQueuedBuilds()
+			}
+			select {
+			case <-ticker.C:
+			case <-buildQueueKick:
+			}
+		}
+	}()
+}
+
+// pollRunningBuilds checks the Jobs of running builds and handles the finished ones
+func pollRunningBuilds() {
+	var running []model.Build
+	database.DB.Where("status = ?", "running").Find(&running)
+
+	for i := range running {
+		build := &running[i]
+		var project model.Project
+		if err := database.DB.First(&project, "id = ?", build.ProjectID).Error; err != nil {
+			finishBuild(build, "failed", "")
+			continue
+		}
+
+		if build.StartedAt != nil && time.Since(*build.StartedAt) > k8s.BuildTimeout(&project)+buildTimeoutGrace {
+			fmt.Printf("[Build] Build %s timed out\n", build.ID)
+			k8s.CancelBuild(&project, build)
+			if finishBuild(build, "failed", "") {
+				markBuildFailed(&project, build)
+			}
+			continue
+		}
+
+		status, stack, err := k8s.BuildJobStatus(&project, build)
+		if err != nil {
+			fmt.Printf("[Build] Error checking build %s: %v\n", build.ID, err)
+			continue
+		}
+		switch status {
+		case "succeeded":
+			if !finishBuild(build, "succeeded", stack) {
+				continue // Handled by another replica
+			}
+			// Deploys can take a while (rollouts, certificates); they don't hold up the queue
+			go deploySucceededBuild(project, *build)
+		case "failed":
+			fmt.Printf("[Build] Build %s failed\n", build.ID)
+			if finishBuild(build, "failed", stack) {
+				markBuildFailed(&project, build)
+			}
+		}
+	}
+}
+
+// deploySucceededBuild deploys what a build produced: a preview, a stage or production
+func deploySucceededBuild(project model.Project, build model.Build) {
+	if build.PreviewNumber > 0 {
+		fmt.Printf("[Build] Build %s succeeded. Deploying preview #%d of %s...\n", build.ID, build.PreviewNumber, project.Name)
+		finishPreview(&project, build.PreviewNumber, "succeeded")
+		return
+	}
+	if build.StageID != nil {
+		fmt.Printf("[Build] Build %s succeeded. Deploying stage of %s...\n", build.ID, project.Name)
+		finishStageBuild(&project, &build, "succeeded")
+		return
+	}
+	fmt.Printf("[Build] Build %s succeeded. Deploying %s...\n", build.ID, project.Name)
+	releaseProductionBuild(&project, &build)
+	database.DB.Model(&project).Update("status", "deploying")
+	if err := redeployProject(&project); err != nil {
+		fmt.Printf("[Build] Deploy failed for %s: %v\n", project.Name, err)
+		database.DB.Model(&project).Update("status", "error")
+		return
+	}
+	database.DB.Model(&project).Update("status", "running")
+}
+
+// dispatchQueuedBuilds starts queued builds in FIFO order while slots are free.
+// A user at their own limit doesn't hold up builds of other users.
+func dispatchQueuedBuilds() {
+	var runningCount int64
+	database.DB.Model(&model.Build{}).Where("status = ?", "running").Count(&runningCount)
+	free := config.App.MaxConcurrentBuilds - int(runningCount)
+	if free <= 0 {
+		return
+	}
+
+	var queued []model.Build
+	database.DB.Where("status = ?", "queued").Order("created_at").Find(&queued)
+
+	for i := range queued {
+		if free <= 0 {
+			return
+		}
+		build := &queued[i]
+		var project model.Project
+		if err := database.DB.First(&project, "id = ?", build.ProjectID).Error; err != nil {
+			finishBuild(build, "cancelled", "")
+			continue
+		}
+		if runningBuilds(project.OwnerID) >= int64(quota.For(project.OwnerID).MaxBuilds) {
+			continue
+		}
+
+		// Claim the build so no other replica starts it as well
+		now := time.Now()
+		result := database.DB.Model(&model.Build{}).
+			Where("id = ? AND status = ?", build.ID, "queued").
+			Updates(map[string]interface{}{"status": "running", "started_at": now})
+		if result.RowsAffected != 1 {
+			continue
+		}
+		build.Status = "running"
+		build.StartedAt = &now
+		free--
+
+		if err := k8s.StartBuild(&project, build, gitCredentials(&project)); err != nil {
+			fmt.Printf("[Build] Failed to start build %s: %v\n", build.ID, err)
+			if finishBuild(build, "failed", "") {
+				markBuildFailed(&project, build)
+			}
+			free++
+			continue
+		}
+
+		// A cancel between the claim and StartBuild found no Job to delete yet
+		var current model.Build
+		if err := database.DB.Select("status").Where("id = ?", build.ID).First(&current).Error; err == nil && current.Status != "running" {
+			fmt.Printf("[Build] Build %s was %s while starting\n", build.ID, current.Status)
+			k8s.CancelBuild(&project, build)
+			free++
+		}
+	}
+}
+
+// markBuildFailed records a failed build on the project, its stage, or the pull request of a preview build
+func markBuildFailed(project *model.Project, build *model.Build) {
+	if build.PreviewNumber > 0 {
+		finishPreview(project, build.PreviewNumber, "failed")
+		return
+	}
+	if build.StageID != nil {
+		finishStageBuild(project, build, "failed")
+		return
+	}
+	database.DB.Model(project).Update("status", "error")
+}
+
+// lastBuildBranch returns the branch of the project's most recent production build, for rebuilds
+func lastBuildBranch(projectID string) string {
+	var build model.Build
+	if err := database.DB.Where("project_id = ? AND preview_number = ? AND stage_id IS NULL", projectID, 0).Order("created_at DESC").First(&build).Error; err != nil || build.Branch == "" {
+		return "main"
+	}
+	return build.Branch
+}
+
+// runningBuilds counts the user's builds that currently occupy a slot
+func runningBuilds(userID string) int64 {
+	var count int64
+	database.DB.Model(&model.Build{}).
+		Joins("JOIN projects ON projects.id = builds.project_id").
+		Where("projects.owner_id = ? AND builds.status = ?", userID, "running").
+		Count(&count)
+	return count
+}
+
+// finishBuild moves an active build to a final status. It reports false if the build
+// was no longer active, e.g. because another replica or a cancel got there first.
+func finishBuild(build *model.Build, status, stack string) bool {
+	now := time.Now()
+	updates := map[string]interface{}{"status": status, "finished_at": now}
+	if stack != "" {
+		updates["stack"] = stack
+	}
+	result := database.DB.Model(&model.Build{}).
+		Where("id = ? AND status IN ?", build.ID, []string{"queued", "running"}).
+		Updates(updates)
+	if result.RowsAffected != 1 {
+		return false
+	}
+	build.Status = status
+	build.FinishedAt = &now
+	if stack != "" {
+		build.Stack = stack
+	}
+	return true
+}
+
+// cancelBuild stops a queued or running build. The Job is deleted either way: the dispatcher
+// may have claimed a build read as queued, and a Job it starts afterwards is caught there.
+func cancelBuild(project *model.Project, build *model.Build) bool {
+	if !finishBuild(build, "cancelled", "") {
+		return false
+	}
+	k8s.CancelBuild(project, build)
+	kickBuildQueue()
+	return true
+}
+
+// withQueuePositions fills QueuePosition for queued builds (1 = next to start)
+func withQueuePositions(builds []model.Build) {
+	for i := range builds {
+		if builds[i].Status != "queued" {
+			continue
+		}
+		var ahead int64
+		database.DB.Model(&model.Build{}).
+			Where("status = ? AND created_at < ?", "queued", builds[i].CreatedAt).
+			Count(&ahead)
+		builds[i].QueuePosition = int(ahead) + 1
+	}
+}
+
+// GetProjectBuilds lists the project's most recent builds
+func GetProjectBuilds(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	builds := []model.Build{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("created_at DESC").Limit(20).Find(&builds).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch builds"})
+	}
+	withQueuePositions(builds)
+	return c.JSON(http.StatusOK, builds)
+}
+
+// CancelProjectBuild cancels a queued or running build
+func CancelProjectBuild(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	buildID := c.Param("buildId")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var build model.Build
+	if err := database.DB.Where("id = ? AND project_id = ?", buildID, project.ID).First(&build).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Build not found"})
+	}
+
+	if !cancelBuild(&project, &build) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Build is already " + build.Status})
+	}
+
+	if build.PreviewNumber > 0 {
+		finishPreview(&project, build.PreviewNumber, "cancelled")
+		return c.JSON(http.StatusOK, build)
+	}
+	if build.StageID != nil {
+		finishStageBuild(&project, &build, "cancelled")
+		return c.JSON(http.StatusOK, build)
+	}
+
+	// The previous deployment (if any) keeps serving, or stays stopped
+	status := k8s.DeployedStatus(&project)
+	if status == "" {
+		status = "error"
+	}
+	var active int64
+	database.DB.Model(&model.Build{}).
+		Where("project_id = ? AND preview_number = ? AND stage_id IS NULL AND status IN ?", project.ID, 0, []string{"queued", "running"}).
+		Count(&active)
+	if active > 0 {
+		status = "building" // another build of the project is still on its way
+	}
+	database.DB.Model(&project).Update("status", status)
+
+	return c.JSON(http.StatusOK, build)
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/dns"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AddDomainRequest struct {
+	Host    string `json:"host"`
+	StageID string `json:"stageId"` // empty routes the domain to production
+}
+
+var hostnamePattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// domainTXTName is the record the user must create to prove ownership of host
+func domainTXTName(host string) string {
+	return "_foundry-challenge." + host
+}
+
+func domainTXTValue(token string) string {
+	return "foundry-verification=" + token
+}
+
+// withDomainInfo fills the dynamic fields (TXT instructions and live cert status)
+func withDomainInfo(project *model.Project, d *model.Domain) {
+	d.TXTName = domainTXTName(d.Host)
+	d.TXTValue = domainTXTValue(d.VerificationToken)
+	if d.Verified {
+		d.CertStatus = k8s.GetCertificateStatus(project, k8s.DomainSecretName(d.ID))
+	} else {
+		d.CertStatus = "pending"
+	}
+}
+
+// redeployDomainTarget re-applies the Ingress the domain is routed through:
+// its stage's, or production's. Stages without a release pick it up on their first deploy.
+func redeployDomainTarget(project *model.Project, domain *model.Domain) error {
+	if domain.StageID == nil {
+		return redeployProject(project)
+	}
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", *domain.StageID, project.ID).First(&stage).Error; err != nil || stage.Image == "" {
+		return nil
+	}
+	return deployStage(project, &stage)
+}
+
+// GetProjectDomains lists the project's custom domains with verification and certificate status
+func GetProjectDomains(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	domains := []model.Domain{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("created_at").Find(&domains).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch domains"})
+	}
+	for i := range domains {
+		withDomainInfo(&project, &domains[i])
+	}
+	return c.JSON(http.StatusOK, domains)
+}
+
+// AddProjectDomain attaches an unverified custom domain and returns the TXT record to create
+func AddProjectDomain(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var req AddDomainRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(req.Host)), ".")
+	if !hostnamePattern.MatchString(host) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid domain name"})
+	}
+	if strings.HasSuffix(host, "."+config.App.BaseDomain) || host == config.App.BaseDomain {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Domain is reserved for Foundry"})
+	}
+
+	var stageID *string
+	if req.StageID != "" {
+		var stage model.Stage
+		if err := database.DB.Where("id = ? AND project_id = ?", req.StageID, project.ID).First(&stage).Error; err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Stage not found"})
+		}
+		stageID = &stage.ID
+	}
+
+	// Other projects may claim the host too; whoever proves ownership first gets it
+	var count int64
+	database.DB.Model(&model.Domain{}).Where("host = ? AND (verified = ? OR project_id = ?)", host, true, project.ID).Count(&count)
+	if count > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Domain is already in use"})
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate verification token"})
+	}
+
+	domain := model.Domain{
+		ProjectID:         project.ID,
+		StageID:           stageID,
+		Host:              host,
+		VerificationToken: hex.EncodeToString(token),
+	}
+	if err := database.DB.Create(&domain).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to add domain"})
+	}
+
+	withDomainInfo(&project, &domain)
+	return c.JSON(http.StatusCreated, domain)
+}
+
+// VerifyProjectDomain checks the TXT record and, once it matches, routes the domain to the project or its stage
+func VerifyProjectDomain(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	domainID := c.Param("domainId")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var domain model.Domain
+	if err := database.DB.Where("id = ? AND project_id = ?", domainID, project.ID).First(&domain).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Domain not found"})
+	}
+
+	if !domain.Verified {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		records, err := dns.Default.LookupTXT(ctx, domainTXTName(domain.Host))
+		if err != nil {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error": fmt.Sprintf("TXT record %s not found", domainTXTName(domain.Host)),
+			})
+		}
+
+		expected := domainTXTValue(domain.VerificationToken)
+		found := false
+		for _, r := range records {
+			if strings.TrimSpace(r) == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+				"error": fmt.Sprintf("TXT record %s does not contain %s", domainTXTName(domain.Host), expected),
+			})
+		}
+
+		var count int64
+		database.DB.Model(&model.Domain{}).Where("host = ? AND verified = ?", domain.Host, true).Count(&count)
+		if count > 0 {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Domain was verified by another project"})
+		}
+
+		// The verified claim takes the host; the unique index on verified hosts settles a race
+		now := time.Now()
+		domain.Verified = true
+		domain.VerifiedAt = &now
+		tx := database.DB.Begin()
+		if err := tx.Save(&domain).Error; err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save domain"})
+		}
+		if err := tx.Where("host = ? AND id <> ? AND verified = ?", domain.Host, domain.ID, false).Delete(&model.Domain{}).Error; err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save domain"})
+		}
+		if err := tx.Commit().Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+		}
+	}
+
+	if k8s.Client != nil {
+		if err := redeployDomainTarget(&project, &domain); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+	}
+
+	withDomainInfo(&project, &domain)
+	return c.JSON(http.StatusOK, domain)
+}
+
+// DeleteProjectDomain detaches a custom domain and removes it from the Ingress
+func DeleteProjectDomain(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	domainID := c.Param("domainId")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var domain model.Domain
+	if err := database.DB.Where("id = ? AND project_id = ?", domainID, project.ID).First(&domain).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Domain not found"})
+	}
+
+	if err := database.DB.Delete(&domain).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete domain"})
+	}
+
+	if domain.Verified && k8s.Client != nil {
+		if err := redeployDomainTarget(&project, &domain); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+		if err := k8s.DeleteDomainSecret(&project, domain.ID); err != nil {
+			c.Logger().Errorf("Failed to delete TLS secret for domain %s: %v", domain.Host, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Domain removed"})
+}
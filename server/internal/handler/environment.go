@@ -58,6 +58,10 @@ func CreateEnvironment(c echo.Context) error {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save variables"})
 		}
 	}
+	if err := recordEnvVersion(tx, "environment", env.ID, userID, "", envRequestMap(req.Variables)); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record env history"})
+	}
 
 	if err := tx.Commit().Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
@@ -105,13 +109,23 @@ func DeleteEnvironment(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Environment not found"})
 	}
 
-	if err := database.DB.Delete(&env).Error; err != nil {
+	// The environment goes together with its version history
+	tx := database.DB.Begin()
+	if err := deleteEnvVersions(tx, "environment", env.ID); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete environment history"})
+	}
+	if err := tx.Delete(&env).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete environment"})
+	}
+	if err := tx.Commit().Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete environment"})
 	}
 
 	// Delete K8s Secret
 	if k8s.Client != nil {
-		if err := k8s.DeleteEnvironmentSecret(id); err != nil {
+		if err := k8s.DeleteEnvironmentSecret(id, userID); err != nil {
 			c.Logger().Errorf("Failed to delete K8s secret for env %s: %v", id, err)
 		}
 	}
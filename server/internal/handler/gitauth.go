@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/crypto"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// applyGitAuthRequest sets the project's clone method and validates its repository URL.
+// It returns the credential to store for the project (nil when none is needed).
+// Switching to ssh generates a deploy key; an empty token keeps the stored one.
+func applyGitAuthRequest(project *model.Project, req model.GitAuthRequest) (*model.GitCredential, error) {
+	auth := req.Auth
+	if auth == "" {
+		auth = "github"
+	}
+
+	var existing model.GitCredential
+	if project.ID != "" {
+		database.DB.Where("project_id = ?", project.ID).First(&existing)
+	}
+	wasSSH := project.GitAuth == "ssh" && existing.PrivateKey != ""
+
+	project.GitAuth = auth
+	project.GitKnownHosts = ""
+	if err := k8s.ValidateRepoURL(project); err != nil {
+		return nil, err
+	}
+
+	switch auth {
+	case "github", "none":
+		project.DeployPublicKey = ""
+		return nil, nil
+	case "token":
+		token := req.Token
+		if token == "" {
+			token = existing.Token
+		}
+		if token == "" {
+			return nil, fmt.Errorf("token is required")
+		}
+		username := strings.TrimSpace(req.Username)
+		if username == "" {
+			username = "oauth2"
+		}
+		project.DeployPublicKey = ""
+		return &model.GitCredential{Username: username, Token: token}, nil
+	case "ssh":
+		// Without host keys the deploy key would be offered to whoever answers for the host
+		project.GitKnownHosts = strings.TrimSpace(req.KnownHosts)
+		if project.GitKnownHosts == "" {
+			return nil, fmt.Errorf("knownHosts is required for ssh auth (e.g. the output of ssh-keyscan <host>)")
+		}
+		if wasSSH {
+			return &existing, nil
+		}
+		return newDeployKey(project)
+	default:
+		return nil, fmt.Errorf("git auth must be github, token, ssh or none")
+	}
+}
+
+// newDeployKey generates a deploy key for the project and returns its private half
+func newDeployKey(project *model.Project) (*model.GitCredential, error) {
+	privateKey, publicKey, err := crypto.GenerateDeployKey("foundry-" + project.Slug)
+	if err != nil {
+		return nil, err
+	}
+	project.DeployPublicKey = publicKey
+	return &model.GitCredential{PrivateKey: privateKey}, nil
+}
+
+// saveGitCredential stores the project's clone secret, or removes it when cred is nil
+func saveGitCredential(tx *gorm.DB, project *model.Project, cred *model.GitCredential) error {
+	if cred == nil {
+		return tx.Where("project_id = ?", project.ID).Delete(&model.GitCredential{}).Error
+	}
+	cred.ProjectID = project.ID
+	return tx.Save(cred).Error
+}
+
+// gitCredentials returns what the project's builds clone with
+func gitCredentials(project *model.Project) k8s.GitCredentials {
+	switch project.GitAuth {
+	case "none":
+		return k8s.GitCredentials{}
+	case "token", "ssh":
+		var cred model.GitCredential
+		if err := database.DB.Where("project_id = ?", project.ID).First(&cred).Error; err != nil {
+			fmt.Printf("[Build] No git credential for %s: %v\n", project.Name, err)
+			return k8s.GitCredentials{}
+		}
+		return k8s.GitCredentials{
+			Username:   cred.Username,
+			Token:      cred.Token,
+			PrivateKey: cred.PrivateKey,
+			KnownHosts: project.GitKnownHosts,
+		}
+	default:
+		var user model.User
+		database.DB.Select("access_token").Where("id = ?", project.OwnerID).First(&user)
+		return k8s.GitCredentials{Token: user.AccessToken}
+	}
+}
+
+// RotateDeployKey replaces the project's deploy key. The new public key has to be added to the repository.
+func RotateDeployKey(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+	if project.GitAuth != "ssh" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Project does not clone over ssh"})
+	}
+
+	cred, err := newDeployKey(&project)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate deploy key"})
+	}
+	tx := database.DB.Begin()
+	if err := saveGitCredential(tx, &project, cred); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save deploy key"})
+	}
+	if err := tx.Model(&project).Update("deploy_public_key", project.DeployPublicKey).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save deploy key"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Transaction commit failed"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"deployPublicKey": project.DeployPublicKey})
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+var githubClient = &http.Client{Timeout: 15 * time.Second}
+
+var (
+	// errGithubUnauthorized means the stored token was revoked or expired; the user has to log in again
+	errGithubUnauthorized = errors.New("GitHub token is no longer valid, please log in again")
+	// errGithubNotFound is also returned for private repositories the token can't see
+	errGithubNotFound = errors.New("not found on GitHub")
+	// errGithubUnavailable wraps network errors and unexpected responses
+	errGithubUnavailable = errors.New("GitHub is unavailable")
+)
+
+// GithubRepo is a repository the user can deploy
+type GithubRepo struct {
+	FullName      string    `json:"fullName"`
+	Name          string    `json:"name"`
+	Owner         string    `json:"owner"`
+	Private       bool      `json:"private"`
+	DefaultBranch string    `json:"defaultBranch"`
+	CloneURL      string    `json:"cloneUrl"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// GithubBranch is a branch of a repository
+type GithubBranch struct {
+	Name      string `json:"name"`
+	CommitSHA string `json:"commitSha"`
+	Protected bool   `json:"protected"`
+}
+
+// githubGet calls the GitHub REST API with the user's token and decodes the response into out.
+// It reports whether GitHub has a next page (Link header).
+func githubGet(ctx context.Context, token, path string, query url.Values, out interface{}) (bool, error) {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	resp, err := githubRequest(ctx, http.MethodGet, token, path, nil, out)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(resp.Header.Get("Link"), `rel="next"`), nil
+}
+
+// githubRequest sends a GitHub REST API request with an optional JSON body and
+// decodes a JSON response into out (if not nil)
+func githubRequest(ctx context.Context, method, token, path string, body, out interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, githubAPIURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errGithubUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusUnauthorized:
+		return nil, errGithubUnauthorized
+	case http.StatusNotFound:
+		return nil, errGithubNotFound
+	default:
+		return nil, fmt.Errorf("%w: %s", errGithubUnavailable, resp.Status)
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("%w: invalid response: %v", errGithubUnavailable, err)
+		}
+	}
+	return resp, nil
+}
+
+// githubPagination reads ?page= and ?perPage= (GitHub allows at most 100 per page)
+func githubPagination(c echo.Context) (int, int) {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(c.QueryParam("perPage"))
+	if err != nil || perPage < 1 {
+		perPage = 30
+	}
+	if perPage > 100 {
+		perPage = 100
+	}
+	return page, perPage
+}
+
+// githubToken loads the user's stored GitHub access token
+func githubToken(userID string) (string, error) {
+	var user model.User
+	if err := database.DB.Select("access_token").Where("id = ?", userID).First(&user).Error; err != nil {
+		return "", err
+	}
+	return user.AccessToken, nil
+}
+
+func githubErrorResponse(c echo.Context, err error) error {
+	switch err {
+	case errGithubUnauthorized:
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errGithubNotFound:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Repository not found"})
+	default:
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+}
+
+// GetGithubRepos lists the repositories the user can access, most recently updated first
+func GetGithubRepos(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	token, err := githubToken(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+	page, perPage := githubPagination(c)
+
+	var repos []struct {
+		FullName      string    `json:"full_name"`
+		Name          string    `json:"name"`
+		Private       bool      `json:"private"`
+		DefaultBranch string    `json:"default_branch"`
+		CloneURL      string    `json:"clone_url"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		Owner         struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	}
+	query := url.Values{
+		"sort":        {"updated"},
+		"affiliation": {"owner,collaborator,organization_member"},
+		"page":        {strconv.Itoa(page)},
+		"per_page":    {strconv.Itoa(perPage)},
+	}
+	hasNext, err := githubGet(c.Request().Context(), token, "/user/repos", query, &repos)
+	if err != nil {
+		return githubErrorResponse(c, err)
+	}
+
+	items := make([]GithubRepo, 0, len(repos))
+	for _, r := range repos {
+		items = append(items, GithubRepo{
+			FullName:      r.FullName,
+			Name:          r.Name,
+			Owner:         r.Owner.Login,
+			Private:       r.Private,
+			DefaultBranch: r.DefaultBranch,
+			CloneURL:      r.CloneURL,
+			UpdatedAt:     r.UpdatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items":   items,
+		"page":    page,
+		"perPage": perPage,
+		"hasNext": hasNext,
+	})
+}
+
+// GetGithubBranches lists the branches of a repository
+func GetGithubBranches(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	token, err := githubToken(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+	page, perPage := githubPagination(c)
+
+	var branches []struct {
+		Name      string `json:"name"`
+		Protected bool   `json:"protected"`
+		Commit    struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/branches", url.PathEscape(c.Param("owner")), url.PathEscape(c.Param("repo")))
+	query := url.Values{
+		"page":     {strconv.Itoa(page)},
+		"per_page": {strconv.Itoa(perPage)},
+	}
+	hasNext, err := githubGet(c.Request().Context(), token, path, query, &branches)
+	if err != nil {
+		return githubErrorResponse(c, err)
+	}
+
+	items := make([]GithubBranch, 0, len(branches))
+	for _, b := range branches {
+		items = append(items, GithubBranch{Name: b.Name, CommitSHA: b.Commit.SHA, Protected: b.Protected})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"items":   items,
+		"page":    page,
+		"perPage": perPage,
+		"hasNext": hasNext,
+	})
+}
+
+// githubRepoPath extracts "owner/repo" from a github.com clone URL
+func githubRepoPath(repoURL string) (string, string, bool) {
+	u, err := url.Parse(repoURL)
+	if err != nil || !strings.EqualFold(u.Hostname(), "github.com") {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// resolveGithubBranch checks that the repository and branch exist before a build is queued,
+// so typos fail right away instead of in the clone step. An empty branch resolves to the
+// repository's default branch.
+func resolveGithubBranch(ctx context.Context, token, repoURL, branch string) (string, error) {
+	owner, repo, ok := githubRepoPath(repoURL)
+	if !ok {
+		return "", fmt.Errorf("repoUrl must look like https://github.com/<owner>/<repo>")
+	}
+	repoPath := fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+
+	if branch == "" {
+		var info struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if _, err := githubGet(ctx, token, repoPath, nil, &info); err != nil {
+			if err == errGithubNotFound {
+				return "", fmt.Errorf("repository %s/%s not found or not accessible", owner, repo)
+			}
+			return "", err
+		}
+		return info.DefaultBranch, nil
+	}
+
+	var info struct {
+		Name string `json:"name"`
+	}
+	if _, err := githubGet(ctx, token, repoPath+"/branches/"+url.PathEscape(branch), nil, &info); err != nil {
+		if err == errGithubNotFound {
+			return "", fmt.Errorf("branch %q not found in %s/%s (or the repository is not accessible)", branch, owner, repo)
+		}
+		return "", err
+	}
+	return branch, nil
+}
@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// recordEnvVersion stores a new snapshot of the given variables for a project or environment group.
+// It must be called inside the same transaction that changes the variables.
+func recordEnvVersion(tx *gorm.DB, targetType, targetID, userID, note string, vars map[string]string) error {
+	// Concurrent changes of the target wait for this transaction, so each gets the next number.
+	// The unique index on (target_type, target_id, version) backs this up.
+	var target interface{} = &model.Project{}
+	if targetType == "environment" {
+		target = &model.Environment{}
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", targetID).Take(target).Error; err != nil {
+		return err
+	}
+
+	var latest int
+	if err := tx.Model(&model.EnvVersion{}).
+		Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+		return err
+	}
+
+	version := model.EnvVersion{
+		TargetType: targetType,
+		TargetID:   targetID,
+		Version:    latest + 1,
+		ChangedBy:  userID,
+		Note:       note,
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return err
+	}
+
+	for k, v := range vars {
+		if k == "" {
+			continue
+		}
+		item := model.EnvVersionVar{
+			EnvVersionID: version.ID,
+			Key:          k,
+			Value:        v,
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEnvVersions removes the whole history of a project or environment group, variables first
+func deleteEnvVersions(tx *gorm.DB, targetType, targetID string) error {
+	versions := tx.Model(&model.EnvVersion{}).Select("id").Where("target_type = ? AND target_id = ?", targetType, targetID)
+	if err := tx.Where("env_version_id IN (?)", versions).Delete(&model.EnvVersionVar{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("target_type = ? AND target_id = ?", targetType, targetID).Delete(&model.EnvVersion{}).Error
+}
+
+// envRequestMap converts request variables into a key/value map, skipping empty keys
+func envRequestMap(vars []model.EnvVarRequest) map[string]string {
+	envMap := make(map[string]string)
+	for _, v := range vars {
+		if v.Key == "" {
+			continue
+		}
+		envMap[v.Key] = v.Value
+	}
+	return envMap
+}
+
+// findEnvVersion loads a single version (with decrypted variables) as a key/value map
+func findEnvVersion(targetType, targetID, versionParam string) (map[string]string, int, error) {
+	number, err := strconv.Atoi(versionParam)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid version %q", versionParam)
+	}
+
+	var version model.EnvVersion
+	if err := database.DB.Preload("Variables").
+		Where("target_type = ? AND target_id = ? AND version = ?", targetType, targetID, number).
+		First(&version).Error; err != nil {
+		return nil, 0, fmt.Errorf("version %d not found", number)
+	}
+
+	vars := make(map[string]string)
+	for _, v := range version.Variables {
+		vars[v.Key] = v.Value
+	}
+	return vars, number, nil
+}
+
+// diffEnvMaps compares two variable sets by key
+func diffEnvMaps(from, to map[string]string) []model.EnvDiffEntry {
+	diff := []model.EnvDiffEntry{}
+	for k, old := range from {
+		if cur, ok := to[k]; !ok {
+			diff = append(diff, model.EnvDiffEntry{Key: k, Change: "removed", From: old})
+		} else if cur != old {
+			diff = append(diff, model.EnvDiffEntry{Key: k, Change: "changed", From: old, To: cur})
+		}
+	}
+	for k, cur := range to {
+		if _, ok := from[k]; !ok {
+			diff = append(diff, model.EnvDiffEntry{Key: k, Change: "added", To: cur})
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i].Key < diff[j].Key })
+	return diff
+}
+
+// listEnvVersions returns the version history (without values) for a target, newest first
+func listEnvVersions(c echo.Context, targetType, targetID string) error {
+	versions := []model.EnvVersion{}
+	if err := database.DB.Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("version DESC").Find(&versions).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch env history"})
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// diffEnvVersions compares the versions given by the "from" and "to" query params
+func diffEnvVersions(c echo.Context, targetType, targetID string) error {
+	from, fromVersion, err := findEnvVersion(targetType, targetID, c.QueryParam("from"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	to, toVersion, err := findEnvVersion(targetType, targetID, c.QueryParam("to"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"from":    fromVersion,
+		"to":      toVersion,
+		"changes": diffEnvMaps(from, to),
+	})
+}
+
+// GetProjectEnvVersions lists the env var history of a project
+func GetProjectEnvVersions(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+	return listEnvVersions(c, "project", project.ID)
+}
+
+// DiffProjectEnvVersions compares two env var versions of a project by key
+func DiffProjectEnvVersions(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+	return diffEnvVersions(c, "project", project.ID)
+}
+
+// RestoreProjectEnvVersion replaces the project's custom env vars with a prior version and redeploys
+func RestoreProjectEnvVersion(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	vars, number, err := findEnvVersion("project", project.ID, c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("project_id = ?", project.ID).Delete(&model.ProjectEnv{}).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to clean old env vars"})
+	}
+	for k, v := range vars {
+		env := model.ProjectEnv{ProjectID: project.ID, Key: k, Value: v}
+		if err := tx.Create(&env).Error; err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save env vars"})
+		}
+	}
+	if err := recordEnvVersion(tx, "project", project.ID, userID, fmt.Sprintf("restored from v%d", number), vars); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record env history"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	if k8s.Client != nil {
+		if err := redeployProject(&project); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+		redeployStages(&project)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Restored env vars from v%d", number)})
+}
+
+// GetEnvironmentVersions lists the variable history of an environment group
+func GetEnvironmentVersions(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	id := c.Param("id")
+
+	var env model.Environment
+	if err := database.DB.Where("id = ? AND owner_id = ?", id, userID).First(&env).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Environment not found"})
+	}
+	return listEnvVersions(c, "environment", env.ID)
+}
+
+// DiffEnvironmentVersions compares two versions of an environment group by key
+func DiffEnvironmentVersions(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	id := c.Param("id")
+
+	var env model.Environment
+	if err := database.DB.Where("id = ? AND owner_id = ?", id, userID).First(&env).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Environment not found"})
+	}
+	return diffEnvVersions(c, "environment", env.ID)
+}
+
+// RestoreEnvironmentVersion replaces the group's variables with a prior version,
+// updates its Secret and redeploys every running project linked to the group
+func RestoreEnvironmentVersion(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	id := c.Param("id")
+
+	var env model.Environment
+	if err := database.DB.Where("id = ? AND owner_id = ?", id, userID).First(&env).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Environment not found"})
+	}
+
+	vars, number, err := findEnvVersion("environment", env.ID, c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Where("environment_id = ?", env.ID).Delete(&model.EnvironmentVar{}).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to clean old variables"})
+	}
+	for k, v := range vars {
+		ev := model.EnvironmentVar{EnvironmentID: env.ID, Key: k, Value: v}
+		if err := tx.Create(&ev).Error; err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save variables"})
+		}
+	}
+	if err := recordEnvVersion(tx, "environment", env.ID, userID, fmt.Sprintf("restored from v%d", number), vars); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record env history"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	if k8s.Client != nil {
+		if err := k8s.CreateEnvironmentSecret(env.ID, userID, vars); err != nil {
+			c.Logger().Errorf("Failed to update K8s secret for env %s: %v", env.ID, err)
+		}
+
+		// Redeploy every running project using this group
+		var projects []model.Project
+		database.DB.Joins("JOIN project_environments ON project_environments.project_id = projects.id").
+			Where("project_environments.environment_id = ? AND projects.status = ?", env.ID, "running").Find(&projects)
+		for i := range projects {
+			if err := redeployProject(&projects[i]); err != nil {
+				c.Logger().Errorf("Failed to redeploy project %s after env restore: %v", projects[i].ID, err)
+			}
+			redeployStages(&projects[i])
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("Restored environment from v%d", number)})
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"foundry-server/internal/registry"
+	"strings"
+	"time"
+)
+
+// applyImageSource turns the project into an image project running the given reference
+func applyImageSource(project *model.Project, image string) error {
+	ref, err := registry.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	project.SourceType = "image"
+	project.Image = strings.TrimSpace(image)
+	project.ImageDigest = ref.Digest
+	project.RepoURL = ""
+	return nil
+}
+
+// resolveImageDigest looks up the digest the project's image tag currently points to.
+// The project's registry credentials are used when the image lives on that registry.
+func resolveImageDigest(project *model.Project) (string, error) {
+	ref, err := registry.ParseReference(project.Image)
+	if err != nil {
+		return "", err
+	}
+
+	var creds registry.Credentials
+	if project.RegistryID != nil {
+		var reg model.Registry
+		if err := database.DB.First(&reg, "id = ?", *project.RegistryID).Error; err == nil && reg.Server == ref.Host {
+			creds = registry.Credentials{Username: reg.Username, Password: reg.Password}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return registry.Digest(ctx, ref, creds)
+}
+
+// pinImageDigest pins the project to the digest its tag points to now. If the registry
+// can't be asked, the previous pin is kept (or the tag is pulled as is when there is none).
+func pinImageDigest(project *model.Project) {
+	digest, err := resolveImageDigest(project)
+	if err != nil {
+		fmt.Printf("[Image] Could not resolve %s for %s: %v\n", project.Image, project.Name, err)
+		return
+	}
+	if digest != project.ImageDigest {
+		project.ImageDigest = digest
+		database.DB.Model(project).Update("image_digest", digest)
+	}
+}
+
+// deployImageProject pins the current digest and deploys it. Image projects skip the build queue.
+func deployImageProject(project *model.Project) {
+	database.DB.Model(project).Update("status", "deploying")
+	pinImageDigest(project)
+	if err := redeployProject(project); err != nil {
+		fmt.Printf("[Image] Deploy failed for %s: %v\n", project.Name, err)
+		database.DB.Model(project).Update("status", "error")
+		return
+	}
+	database.DB.Model(project).Update("status", "running")
+}
+
+// StartImageWatcher periodically redeploys image projects with auto-redeploy
+// whose tag has moved to a new digest since they were deployed
+func StartImageWatcher() {
+	go func() {
+		ticker := time.NewTicker(config.App.ImagePollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if database.DB == nil || k8s.Client == nil {
+				continue
+			}
+
+			var projects []model.Project
+			database.DB.Where("source_type = ? AND auto_redeploy = ? AND status = ?", "image", true, "running").Find(&projects)
+			for i := range projects {
+				project := &projects[i]
+				digest, err := resolveImageDigest(project)
+				if err != nil {
+					fmt.Printf("[Image] Could not check %s for %s: %v\n", project.Image, project.Name, err)
+					continue
+				}
+				if digest == project.ImageDigest {
+					continue
+				}
+
+				fmt.Printf("[Image] %s moved to %s. Redeploying %s...\n", project.Image, digest, project.Name)
+				project.ImageDigest = digest
+				database.DB.Model(project).Update("image_digest", digest)
+				if err := redeployProject(project); err != nil {
+					fmt.Printf("[Image] Deploy failed for %s: %v\n", project.Name, err)
+					database.DB.Model(project).Update("status", "error")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// setProjectPeers replaces the project's network allowlist inside tx. Peers must be other
+// projects of the same owner. It returns the peers whose NetworkPolicy has to be refreshed.
+func setProjectPeers(tx *gorm.DB, project *model.Project, peerIDs []string) ([]string, error) {
+	unique := map[string]bool{}
+	for _, id := range peerIDs {
+		if id == project.ID {
+			return nil, fmt.Errorf("a project cannot be its own peer")
+		}
+		unique[id] = true
+	}
+
+	var peers []model.Project
+	if len(unique) > 0 {
+		ids := make([]string, 0, len(unique))
+		for id := range unique {
+			ids = append(ids, id)
+		}
+		if err := tx.Where("id IN ? AND owner_id = ?", ids, project.OwnerID).Find(&peers).Error; err != nil {
+			return nil, err
+		}
+		if len(peers) != len(ids) {
+			return nil, fmt.Errorf("peers must be your own projects")
+		}
+	}
+
+	var previous []string
+	tx.Table("project_peers").Where("project_id = ?", project.ID).Pluck("peer_id", &previous)
+
+	if err := tx.Model(project).Association("Peers").Replace(peers); err != nil {
+		return nil, err
+	}
+
+	affected := previous
+	for _, p := range peers {
+		affected = append(affected, p.ID)
+	}
+	return affected, nil
+}
+
+// loadPeerIDs fills project.PeerIDs from the allowlist
+func loadPeerIDs(project *model.Project) {
+	project.PeerIDs = []string{}
+	database.DB.Table("project_peers").Where("project_id = ?", project.ID).Pluck("peer_id", &project.PeerIDs)
+}
+
+// refreshPeerPolicies re-applies the NetworkPolicies of the given projects so they
+// accept (or stop accepting) connections after an allowlist change
+func refreshPeerPolicies(projectIDs []string) {
+	if k8s.Client == nil || len(projectIDs) == 0 {
+		return
+	}
+	var projects []model.Project
+	database.DB.Where("id IN ?", projectIDs).Find(&projects)
+	for i := range projects {
+		if err := k8s.RefreshNetworkPolicy(&projects[i]); err != nil {
+			fmt.Printf("Network policy error: %v\n", err)
+		}
+	}
+}
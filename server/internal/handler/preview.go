@@ -0,0 +1,357 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// previewStatusContext is the name of the commit status shown on pull requests
+const previewStatusContext = "foundry/preview"
+
+type pullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Title string `json:"title"`
+		Head  struct {
+			Ref  string `json:"ref"`
+			SHA  string `json:"sha"`
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// verifyGithubSignature checks the X-Hub-Signature-256 header of a webhook delivery
+func verifyGithubSignature(body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(config.App.GithubWebhookSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// GithubWebhook receives pull_request events and builds, updates or tears down the
+// previews of every project with previews enabled for the repository and base branch
+func GithubWebhook(c echo.Context) error {
+	if config.App.GithubWebhookSecret == "" {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Webhooks are not configured"})
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, 5<<20))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if !verifyGithubSignature(body, c.Request().Header.Get("X-Hub-Signature-256")) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid signature"})
+	}
+
+	switch c.Request().Header.Get("X-GitHub-Event") {
+	case "ping":
+		return c.JSON(http.StatusOK, map[string]string{"message": "pong"})
+	case "pull_request":
+	default:
+		return c.JSON(http.StatusAccepted, map[string]string{"message": "Event ignored"})
+	}
+
+	var event pullRequestEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid payload"})
+	}
+
+	projects := previewProjects(event.Repository.FullName, event.PullRequest.Base.Ref)
+	for i := range projects {
+		project := &projects[i]
+		switch event.Action {
+		case "opened", "reopened", "synchronize":
+			// Fork code would run with the project's env vars; only branches of the repository get previews
+			if !strings.EqualFold(event.PullRequest.Head.Repo.FullName, event.Repository.FullName) {
+				fmt.Printf("[Preview] Skipping PR #%d of %s: opened from a fork\n", event.Number, event.Repository.FullName)
+				continue
+			}
+			if err := startPreview(project, event); err != nil {
+				fmt.Printf("[Preview] Failed to start preview #%d of %s: %v\n", event.Number, project.Name, err)
+			}
+		case "closed":
+			stopPreview(project, event.Number)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": fmt.Sprintf("%d project(s) updated", len(projects))})
+}
+
+// previewProjects returns the projects with previews enabled that deploy the repository's base branch
+func previewProjects(repoFullName, baseBranch string) []model.Project {
+	var candidates, projects []model.Project
+	database.DB.Where("previews = ? AND source_type = ?", true, "git").Find(&candidates)
+	for _, p := range candidates {
+		owner, repo, ok := githubRepoPath(p.RepoURL)
+		if !ok || !strings.EqualFold(owner+"/"+repo, repoFullName) {
+			continue
+		}
+		if lastBuildBranch(p.ID) != baseBranch {
+			continue
+		}
+		projects = append(projects, p)
+	}
+	return projects
+}
+
+// startPreview queues a build of the pull request's head. Pushes to the pull request
+// cancel the build of the previous head.
+func startPreview(project *model.Project, event pullRequestEvent) error {
+	var preview model.Preview
+	err := database.DB.Where("project_id = ? AND number = ?", project.ID, event.Number).First(&preview).Error
+	if err == nil && preview.HeadSHA == event.PullRequest.Head.SHA && preview.Status != "error" {
+		return nil // Redelivered event, or several projects sharing the repository webhook
+	}
+
+	preview.ProjectID = project.ID
+	preview.Number = event.Number
+	preview.Title = event.PullRequest.Title
+	preview.Branch = event.PullRequest.Head.Ref
+	preview.HeadSHA = event.PullRequest.Head.SHA
+	preview.Status = "building"
+
+	// The preview's host is a single DNS label, like the project's own
+	if name := k8s.PreviewName(project, preview.Number); len(name) > maxSlugLength() {
+		return rejectPreview(project, &preview, "Project slug is too long for a preview host",
+			fmt.Errorf("preview name %s is longer than %d characters", name, maxSlugLength()))
+	}
+	// A preview already building or running keeps its slot across pushes
+	if previewReplicas(project.ID, preview.Number) >= int64(config.App.MaxPreviews) {
+		return rejectPreview(project, &preview, fmt.Sprintf("Preview limit reached (%d per project)", config.App.MaxPreviews),
+			fmt.Errorf("preview limit of %d reached", config.App.MaxPreviews))
+	}
+	if err := checkPreviewQuota(project, preview.Number); err != nil {
+		return rejectPreview(project, &preview, "Not enough quota left for a preview", err)
+	}
+
+	if err := database.DB.Save(&preview).Error; err != nil {
+		return err
+	}
+
+	cancelPreviewBuilds(project, preview.Number)
+	build := model.Build{
+		ProjectID:     project.ID,
+		Branch:        preview.Branch,
+		PreviewNumber: preview.Number,
+		Status:        "queued",
+	}
+	if err := database.DB.Create(&build).Error; err != nil {
+		return err
+	}
+	kickBuildQueue()
+
+	postPreviewStatus(project, &preview, "pending", "Building preview", "")
+	return nil
+}
+
+// rejectPreview records a preview that won't be built and reports why on the pull request.
+// A version deployed for an earlier push is torn down, as it no longer counts against the quota.
+func rejectPreview(project *model.Project, preview *model.Preview, description string, err error) error {
+	preview.Status = "error"
+	preview.DeployURL = ""
+	if saveErr := database.DB.Save(preview).Error; saveErr != nil {
+		return saveErr
+	}
+	cancelPreviewBuilds(project, preview.Number)
+	if deleteErr := k8s.DeletePreview(project, preview.Number); deleteErr != nil {
+		fmt.Printf("[Preview] %v\n", deleteErr)
+	}
+	postPreviewStatus(project, preview, "failure", description, "")
+	return err
+}
+
+// finishPreview deploys a preview once its build succeeded and reports the outcome on the pull request
+func finishPreview(project *model.Project, number int, buildStatus string) {
+	var preview model.Preview
+	if err := database.DB.Where("project_id = ? AND number = ?", project.ID, number).First(&preview).Error; err != nil {
+		return // Pull request closed in the meantime
+	}
+
+	switch buildStatus {
+	case "succeeded":
+		url, err := k8s.DeployPreview(project, number, resolveEnvMap(project))
+		if err != nil {
+			fmt.Printf("[Preview] Deploy failed for #%d of %s: %v\n", number, project.Name, err)
+			database.DB.Model(&preview).Update("status", "error")
+			postPreviewStatus(project, &preview, "error", "Preview deployment failed", "")
+			return
+		}
+		database.DB.Model(&preview).Updates(map[string]interface{}{"status": "running", "deploy_url": url})
+		postPreviewStatus(project, &preview, "success", "Preview is live", url)
+	case "cancelled":
+		database.DB.Model(&preview).Update("status", "error")
+		postPreviewStatus(project, &preview, "error", "Preview build cancelled", "")
+	default:
+		database.DB.Model(&preview).Update("status", "error")
+		postPreviewStatus(project, &preview, "failure", "Preview build failed", "")
+	}
+}
+
+// stopPreview tears a preview down when its pull request is closed or previews are turned off
+func stopPreview(project *model.Project, number int) {
+	cancelPreviewBuilds(project, number)
+	if err := k8s.DeletePreview(project, number); err != nil {
+		fmt.Printf("[Preview] %v\n", err)
+	}
+	database.DB.Where("project_id = ? AND number = ?", project.ID, number).Delete(&model.Preview{})
+}
+
+func cancelPreviewBuilds(project *model.Project, number int) {
+	var active []model.Build
+	database.DB.Where("project_id = ? AND preview_number = ? AND status IN ?", project.ID, number, []string{"queued", "running"}).Find(&active)
+	for i := range active {
+		cancelBuild(project, &active[i])
+	}
+}
+
+// postPreviewStatus reports the preview on the pull request's head commit, linking to it once it is live
+func postPreviewStatus(project *model.Project, preview *model.Preview, state, description, targetURL string) {
+	owner, repo, ok := githubRepoPath(project.RepoURL)
+	if !ok || preview.HeadSHA == "" {
+		return
+	}
+	token, err := githubToken(project.OwnerID)
+	if err != nil || token == "" {
+		return
+	}
+
+	status := map[string]string{
+		"state":       state,
+		"description": description,
+		"context":     previewStatusContext,
+	}
+	if targetURL != "" {
+		status["target_url"] = targetURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	path := fmt.Sprintf("/repos/%s/%s/statuses/%s", owner, repo, preview.HeadSHA)
+	if _, err := githubRequest(ctx, http.MethodPost, token, path, status, nil); err != nil {
+		fmt.Printf("[Preview] Failed to post status for #%d of %s: %v\n", preview.Number, project.Name, err)
+	}
+}
+
+// registerPreviewHook adds the webhook delivering pull_request events for the project's repository.
+// Projects deploying the same repository share one webhook, since GitHub rejects a second hook
+// with the same URL; an existing one is reused.
+func registerPreviewHook(ctx context.Context, token string, project *model.Project) (int64, error) {
+	if config.App.GithubWebhookURL == "" || config.App.GithubWebhookSecret == "" {
+		return 0, fmt.Errorf("pull request previews are not configured on this installation")
+	}
+	owner, repo, ok := githubRepoPath(project.RepoURL)
+	if !ok {
+		return 0, fmt.Errorf("previews need a github.com repository")
+	}
+
+	var hooks []struct {
+		ID     int64 `json:"id"`
+		Config struct {
+			URL string `json:"url"`
+		} `json:"config"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/hooks", owner, repo)
+	if _, err := githubRequest(ctx, http.MethodGet, token, path+"?per_page=100", nil, &hooks); err != nil {
+		return 0, err
+	}
+	for _, h := range hooks {
+		if h.Config.URL == config.App.GithubWebhookURL {
+			return h.ID, nil
+		}
+	}
+
+	hook := map[string]interface{}{
+		"name":   "web",
+		"active": true,
+		"events": []string{"pull_request"},
+		"config": map[string]string{
+			"url":          config.App.GithubWebhookURL,
+			"content_type": "json",
+			"secret":       config.App.GithubWebhookSecret,
+		},
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := githubRequest(ctx, http.MethodPost, token, path, hook, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// removePreviewHook deletes the project's webhook once no other project with previews deploys
+// the repository. A hook already removed on GitHub is fine.
+func removePreviewHook(ctx context.Context, token string, project *model.Project) error {
+	owner, repo, ok := githubRepoPath(project.RepoURL)
+	if !ok || project.PreviewHookID == 0 {
+		return nil
+	}
+	if repoHasOtherPreviews(project, owner+"/"+repo) {
+		return nil
+	}
+	path := fmt.Sprintf("/repos/%s/%s/hooks/%d", owner, repo, project.PreviewHookID)
+	if _, err := githubRequest(ctx, http.MethodDelete, token, path, nil, nil); err != nil && err != errGithubNotFound {
+		return err
+	}
+	return nil
+}
+
+// repoHasOtherPreviews reports whether another project with previews, of any user, deploys the repository
+func repoHasOtherPreviews(project *model.Project, repoFullName string) bool {
+	var others []model.Project
+	database.DB.Where("previews = ? AND source_type = ? AND id <> ?", true, "git", project.ID).Find(&others)
+	for _, p := range others {
+		owner, repo, ok := githubRepoPath(p.RepoURL)
+		if ok && strings.EqualFold(owner+"/"+repo, repoFullName) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteProjectPreviews removes every preview of the project
+func deleteProjectPreviews(project *model.Project) {
+	var previews []model.Preview
+	database.DB.Where("project_id = ?", project.ID).Find(&previews)
+	for _, p := range previews {
+		stopPreview(project, p.Number)
+	}
+}
+
+// GetProjectPreviews lists the project's pull request previews
+func GetProjectPreviews(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	previews := []model.Preview{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("number DESC").Find(&previews).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch previews"})
+	}
+	return c.JSON(http.StatusOK, previews)
+}
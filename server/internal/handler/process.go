@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxProcessNameLength is the longest resource name a process type may have.
+// Kubernetes appends an 11 character suffix to the Jobs of a CronJob.
+func maxProcessNameLength(process *model.Process) int {
+	if process.Type == "cron" {
+		return 52
+	}
+	return 63
+}
+
+// cronMacros are the schedule shorthands Kubernetes accepts besides the 5-field syntax
+var cronMacros = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// validateProcess checks a process type declaration and that its resource name is free
+func validateProcess(db *gorm.DB, project *model.Project, process *model.Process) error {
+	if !stageNamePattern.MatchString(process.Name) {
+		return fmt.Errorf("process name must start with a letter and contain only lowercase letters, digits and '-'")
+	}
+	// The web process is the project itself
+	if process.Name == "web" || isRolloutSuffix(process.Name) {
+		return fmt.Errorf("process name %q is reserved", process.Name)
+	}
+	if process.Command == "" {
+		return fmt.Errorf("process %s needs a command", process.Name)
+	}
+
+	switch process.Type {
+	case "worker":
+		process.Schedule = ""
+		if process.Replicas < 0 || process.Replicas > config.App.UserMaxReplicas {
+			return fmt.Errorf("replicas of %s must be between 0 and %d", process.Name, config.App.UserMaxReplicas)
+		}
+	case "cron":
+		if fields := strings.Fields(process.Schedule); len(fields) != 5 && !(len(fields) == 1 && cronMacros[fields[0]]) {
+			return fmt.Errorf("schedule of %s must be a 5-field cron expression like \"*/15 * * * *\"", process.Name)
+		}
+		if process.Replicas < 0 || process.Replicas > 1 {
+			return fmt.Errorf("replicas of cron %s must be 0 (paused) or 1", process.Name)
+		}
+	default:
+		return fmt.Errorf("process type must be worker or cron")
+	}
+
+	name := k8s.ProcessName(project, process)
+	if len(name) > maxProcessNameLength(process) {
+		return fmt.Errorf("process name %s is too long for the project slug", process.Name)
+	}
+	// Stages of the project are named the same way
+	var count int64
+	db.Model(&model.Stage{}).Where("project_id = ? AND name = ?", project.ID, process.Name).Count(&count)
+	if count > 0 || !slugAvailable(db, name, project.ID) {
+		return fmt.Errorf("name %s is already taken", name)
+	}
+	return nil
+}
+
+// deployProcesses applies the project's process types if Kubernetes is connected
+func deployProcesses(project *model.Project) error {
+	if k8s.Client == nil {
+		return nil
+	}
+	return k8s.DeployProcesses(project)
+}
+
+// GetProjectProcesses lists the project's worker and cron process types
+func GetProjectProcesses(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	processes := []model.Process{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("name").Find(&processes).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch processes"})
+	}
+	return c.JSON(http.StatusOK, processes)
+}
+
+// SetProjectProcesses declares the project's process types, like a Procfile: declared types
+// are created or updated, types left out are removed. The web process is not part of it.
+func SetProjectProcesses(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var req []model.ProcessRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var existing []model.Process
+	database.DB.Where("project_id = ?", project.ID).Find(&existing)
+	byName := map[string]model.Process{}
+	for _, p := range existing {
+		byName[p.Name] = p
+	}
+
+	processes := make([]model.Process, 0, len(req))
+	seen := map[string]bool{}
+	for _, r := range req {
+		process := model.Process{ProjectID: project.ID, Replicas: 1}
+		if p, ok := byName[strings.TrimSpace(r.Name)]; ok {
+			process = p
+		}
+		process.Name = strings.TrimSpace(r.Name)
+		process.Type = r.Type
+		process.Command = strings.TrimSpace(r.Command)
+		process.Schedule = strings.TrimSpace(r.Schedule)
+		if r.Replicas != nil {
+			process.Replicas = *r.Replicas
+		}
+		if seen[process.Name] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("process %s is declared twice", process.Name)})
+		}
+		seen[process.Name] = true
+		if err := validateProcess(database.DB, &project, &process); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		processes = append(processes, process)
+	}
+
+	if err := checkProcessQuota(&project, processes); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+
+	tx := database.DB.Begin()
+	for i := range processes {
+		if err := tx.Save(&processes[i]).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error saving process: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save processes"})
+		}
+	}
+	for _, p := range existing {
+		if seen[p.Name] {
+			continue
+		}
+		if err := tx.Delete(&model.Process{}, "id = ?", p.ID).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error deleting process: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save processes"})
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	if err := deployProcesses(&project); err != nil {
+		fmt.Printf("Process deploy error: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to deploy processes: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, processes)
+}
+
+// UpdateProjectProcess scales, reschedules or changes the command of a single process type
+func UpdateProjectProcess(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	name := c.Param("name")
+
+	var req model.ProcessUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var process model.Process
+	if err := database.DB.Where("project_id = ? AND name = ?", project.ID, name).First(&process).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Process not found"})
+	}
+
+	if req.Command != nil {
+		process.Command = strings.TrimSpace(*req.Command)
+	}
+	if req.Schedule != nil {
+		process.Schedule = strings.TrimSpace(*req.Schedule)
+	}
+	if req.Replicas != nil {
+		process.Replicas = *req.Replicas
+	}
+	if err := validateProcess(database.DB, &project, &process); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Replicas != nil {
+		var others []model.Process
+		database.DB.Where("project_id = ? AND id <> ?", project.ID, process.ID).Find(&others)
+		if err := checkProcessQuota(&project, append(others, process)); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+	}
+
+	if err := database.DB.Save(&process).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update process"})
+	}
+	if err := deployProcesses(&project); err != nil {
+		fmt.Printf("Process deploy error: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to deploy processes: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, process)
+}
+
+// DeleteProjectProcess removes a single process type and its Deployment or CronJob
+func DeleteProjectProcess(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	name := c.Param("name")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var process model.Process
+	if err := database.DB.Where("project_id = ? AND name = ?", project.ID, name).First(&process).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Process not found"})
+	}
+	if err := database.DB.Delete(&process).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete process"})
+	}
+
+	// Applying the remaining process types removes the deleted one
+	if err := deployProcesses(&project); err != nil {
+		fmt.Printf("Process deploy error: %v\n", err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Process deleted"})
+}
+
+// GetProjectProcessLogs returns the logs of a worker, or of the last run of a cron
+func GetProjectProcessLogs(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	name := c.Param("name")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var process model.Process
+	if err := database.DB.Where("project_id = ? AND name = ?", project.ID, name).First(&process).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Process not found"})
+	}
+
+	if k8s.Client == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Kubernetes not connected"})
+	}
+	logs, err := k8s.GetProcessLogs(&project, &process)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch logs: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"logs": logs})
+}
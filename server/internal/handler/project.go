@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"foundry-server/internal/config"
 	"foundry-server/internal/database"
 	"foundry-server/internal/k8s"
 	"foundry-server/internal/model"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -99,16 +102,18 @@ func CreateProject(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	// 1. Fetch User to get AccessToken
+	// 1. Verify the user exists (the token is used to check the repository; the build queue reads it again)
 	var user model.User
-	if result := database.DB.Select("access_token").Where("id = ?", userID).First(&user); result.Error != nil {
+	if result := database.DB.Select("id", "access_token").Where("id = ?", userID).First(&user); result.Error != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
 	}
 
-	// Default branch to main if empty
+	// Default branch to main if empty (GitHub repositories use their default branch instead)
 	branch := req.Branch
 	if branch == "" {
 		branch = "main"
+	} else if err := k8s.ValidateBranch(branch); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	
 	port := req.Port
@@ -116,9 +121,12 @@ func CreateProject(c echo.Context) error {
 		port = 80
 	}
 
+	var gitCredential *model.GitCredential
+
 	// 2. Create Project Record (Transaction)
 	project := model.Project{
 		Name:    req.Name,
+		Slug:    generateSlug(database.DB, req.Name),
 		RepoURL: req.RepoURL,
 		// Branch:  branch, // Not persisted in DB model yet
 		Port:    port,
@@ -126,11 +134,84 @@ func CreateProject(c echo.Context) error {
 		Status:  "building",
 	}
 
+	if err := applyResourceRequest(&project, req.ResourceRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Health != nil {
+		if err := applyHealthCheckRequest(&project, *req.Health); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	if req.Strategy != nil {
+		if err := applyStrategyRequest(&project, *req.Strategy); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+	switch req.SourceType {
+	case "", "git":
+		project.SourceType = "git"
+		gitReq := model.GitAuthRequest{}
+		if req.Git != nil {
+			gitReq = *req.Git
+		}
+		cred, err := applyGitAuthRequest(&project, gitReq)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		gitCredential = cred
+
+		// Catch typos in the repository or branch now rather than when the build clones
+		if project.GitAuth == "github" {
+			resolved, err := resolveGithubBranch(c.Request().Context(), user.AccessToken, project.RepoURL, req.Branch)
+			switch {
+			case err == nil:
+				branch = resolved
+			case errors.Is(err, errGithubUnauthorized):
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			case errors.Is(err, errGithubUnavailable):
+				// Don't block deploys on a GitHub outage; the clone reports real problems
+				c.Logger().Warnf("Could not verify %s: %v", project.RepoURL, err)
+			default:
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+		}
+		if req.Build != nil {
+			if err := applyBuildRequest(&project, *req.Build); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+		}
+	case "image":
+		if err := applyImageSource(&project, req.Image); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		project.AutoRedeploy = req.AutoRedeploy
+		project.Status = "deploying"
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "sourceType must be git or image"})
+	}
+	registryID, err := resolveRegistryID(userID, req.RegistryID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	project.RegistryID = registryID
+	if err := checkProjectQuota(userID); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+	if err := checkResourceQuota(&project); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+
 	tx := database.DB.Begin()
 	if err := tx.Create(&project).Error; err != nil {
 		tx.Rollback()
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create project"})
 	}
+	if gitCredential != nil {
+		if err := saveGitCredential(tx, &project, gitCredential); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save git credentials"})
+		}
+	}
 
 	// 3. Save Env Vars (Custom)
 	envMap := make(map[string]string)
@@ -149,6 +230,10 @@ func CreateProject(c echo.Context) error {
 		}
 		envMap[env.Key] = env.Value
 	}
+	if err := recordEnvVersion(tx, "project", project.ID, userID, "", envMap); err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record env history"})
+	}
 
     // 4. Link Reusable Environments
     if len(req.EnvironmentIDs) > 0 {
@@ -206,18 +291,35 @@ func CreateProject(c echo.Context) error {
         c.Logger().Infof("Total environment variables for deployment: %d", len(envMap))
     }
 
+	// 5. Network allowlist
+	var affectedPeers []string
+	if len(req.PeerIDs) > 0 {
+		peers, err := setProjectPeers(tx, &project, req.PeerIDs)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		affectedPeers = peers
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Transaction commit failed"})
 	}
+	refreshPeerPolicies(affectedPeers)
+	loadPeerIDs(&project)
 
-	// 4. Trigger K8s Build
+	// 4. Queue K8s Build (started by the build queue once a slot is free)
 	// Note: Verify k8s client is initialized before calling
-	if k8s.Client != nil {
-		if err := k8s.TriggerBuild(project.ID, userID, project.Name, req.RepoURL, branch, user.AccessToken, envMap, project.Port); err != nil {
+	if k8s.Client != nil && project.SourceType == "image" {
+		// Prebuilt image: nothing to build, deploy it right away
+		deploying := project
+		go deployImageProject(&deploying)
+	} else if k8s.Client != nil {
+		if _, err := enqueueBuild(&project, branch); err != nil {
 			// Log error but assume project is created. User can retry build later.
 			// Or update status to error.
 			database.DB.Model(&project).Update("status", "error")
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to trigger build: " + err.Error()})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to queue build: " + err.Error()})
 		}
 	} else {
 		// If K8s is not connected (e.g. dev mode without k8s), we just log it
@@ -238,14 +340,81 @@ func DeleteProject(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
 	}
 
+	// Volumes hold the app's data, so they only go with the project when the user confirms it
+	hasVolumes := k8s.HasVolumes(project.ID)
+	if hasVolumes && c.QueryParam("confirm") != k8s.ResourceName(&project) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Project has persistent volumes; pass confirm=%s to delete their data with it", k8s.ResourceName(&project)),
+		})
+	}
+
+	// Tear down pull request previews and stop receiving their events
+	deleteProjectPreviews(&project)
+	if project.PreviewHookID != 0 {
+		if token, err := githubToken(userID); err == nil {
+			if err := removePreviewHook(c.Request().Context(), token, &project); err != nil {
+				fmt.Printf("Failed to remove webhook of %s: %v\n", projectID, err)
+			}
+		}
+	}
+
+	// Tear down stages (their TLS secrets aren't labeled, so they go one by one)
+	deleteProjectStages(&project)
+
 	// Delete from Kubernetes
 	if k8s.Client != nil {
-		if err := k8s.DeleteProject(projectID); err != nil {
+		if err := k8s.DeleteProject(&project); err != nil {
 			// Log error but proceed to delete from DB? 
 			// Or fail? Best to log and proceed (don't leave zombie DB records)
 			fmt.Printf("Failed to delete K8s resources for %s: %v\n", projectID, err)
 		}
+		if hasVolumes {
+			if err := k8s.DeleteVolumes(&project); err != nil {
+				fmt.Printf("Failed to delete volumes of %s: %v\n", projectID, err)
+			}
+		}
 	}
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Volume{})
+
+	// Delete custom domains (and their TLS secrets)
+	var domains []model.Domain
+	database.DB.Where("project_id = ?", projectID).Find(&domains)
+	for _, d := range domains {
+		if err := k8s.DeleteDomainSecret(&project, d.ID); err != nil {
+			fmt.Printf("Failed to delete TLS secret for %s: %v\n", d.Host, err)
+		}
+	}
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Domain{})
+
+	// Release old slugs still redirecting to this project
+	var redirects []model.SlugRedirect
+	database.DB.Where("project_id = ?", projectID).Find(&redirects)
+	for _, r := range redirects {
+		if err := k8s.DeleteSlugRedirect(project.OwnerID, r.Slug); err != nil {
+			fmt.Printf("Failed to delete redirect %s: %v\n", r.Slug, err)
+		}
+	}
+	database.DB.Where("project_id = ?", projectID).Delete(&model.SlugRedirect{})
+	// Cancel active builds before their records go away
+	var activeBuilds []model.Build
+	database.DB.Where("project_id = ? AND status IN ?", projectID, []string{"queued", "running"}).Find(&activeBuilds)
+	for i := range activeBuilds {
+		cancelBuild(&project, &activeBuilds[i])
+	}
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Build{})
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Release{})
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Rollout{})
+	database.DB.Where("project_id = ?", projectID).Delete(&model.Process{})
+	database.DB.Where("project_id = ?", projectID).Delete(&model.GitCredential{})
+	if err := deleteEnvVersions(database.DB, "project", projectID); err != nil {
+		fmt.Printf("Failed to delete env history of %s: %v\n", projectID, err)
+	}
+
+	// Remove the project from network allowlists
+	var callers []string
+	database.DB.Table("project_peers").Where("peer_id = ?", projectID).Pluck("project_id", &callers)
+	database.DB.Exec("DELETE FROM project_peers WHERE project_id = ? OR peer_id = ?", projectID, projectID)
+	refreshPeerPolicies(callers)
 
 	// Delete from DB
 	if err := database.DB.Delete(&project).Error; err != nil {
@@ -271,6 +440,8 @@ func GetProject(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch env vars"})
 	}
 
+	loadPeerIDs(&project)
+
 	// Combine response
 	response := map[string]interface{}{
 		"project": project,
@@ -295,7 +466,20 @@ func UpdateProject(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found"})
 	}
 
-	// 1. Handle Actions (Start/Stop)
+	// 1. Handle Actions (Start/Stop/Redeploy)
+	if req.Action == "redeploy" {
+		if project.SourceType != "image" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Only image projects can be redeployed; push to the branch to rebuild"})
+		}
+		if k8s.Client == nil {
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Kubernetes not connected"})
+		}
+		if project.Status == "stopped" {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Start the project first"})
+		}
+		go deployImageProject(&project)
+		return c.JSON(http.StatusOK, map[string]string{"message": "Project deploying"})
+	}
 	if req.Action != "" {
 		if k8s.Client != nil {
 			var replicas int32
@@ -303,13 +487,17 @@ func UpdateProject(c echo.Context) error {
 			if req.Action == "stop" {
 				replicas = 0
 				status = "stopped"
+				abortRollouts(&project, "project stopped")
 			} else if req.Action == "start" {
-				replicas = 1
+				replicas = k8s.StartReplicas(&project)
+				if err := checkResourceQuota(&project); err != nil {
+					return quotaErrorResponse(c, err)
+				}
 			} else {
 				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid action"})
 			}
 
-			if err := k8s.ScaleProject(projectID, replicas); err != nil {
+			if err := k8s.ScaleProject(&project, replicas); err != nil {
 				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to scale project"})
 			}
 			
@@ -327,9 +515,25 @@ func UpdateProject(c echo.Context) error {
 	// Ideally: Replace all for simplicity.
 	
 	shouldRedeploy := false
+	shouldRebuild := false
+	shouldPull := false // image projects: resolve the tag again before deploying
+	removePreviews := false
+	previousSlug := ""
+	var affectedPeers []string
 
 	tx := database.DB.Begin()
 
+	// Rename (changes the default host; the old one keeps redirecting for a while)
+	if req.Slug != "" && req.Slug != project.Slug {
+		oldSlug, err := renameProjectSlug(tx, &project, req.Slug)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		previousSlug = oldSlug
+		shouldRedeploy = true
+	}
+
 	// Update Port
 	if req.Port != 0 && req.Port != project.Port {
 		project.Port = req.Port
@@ -341,6 +545,239 @@ func UpdateProject(c echo.Context) error {
 		shouldRedeploy = true
 	}
 
+	// Update Resources
+	if req.Size != "" {
+		if err := applyResourceRequest(&project, req.ResourceRequest); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := checkResourceQuota(&project); err != nil {
+			tx.Rollback()
+			return quotaErrorResponse(c, err)
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating resources: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+		shouldRedeploy = true
+	}
+
+	// Update Scaling
+	if req.Scaling != nil {
+		if err := applyScalingRequest(&project, *req.Scaling); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := checkVolumeScaling(&project); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := checkResourceQuota(&project); err != nil {
+			tx.Rollback()
+			return quotaErrorResponse(c, err)
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating scaling: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+		shouldRedeploy = true
+	}
+
+	// Update Health Checks
+	if req.Health != nil {
+		if err := applyHealthCheckRequest(&project, *req.Health); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating health check: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+		shouldRedeploy = true
+	}
+
+	// Update Image (image projects only)
+	if req.Image != nil || req.AutoRedeploy != nil {
+		if project.SourceType != "image" {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Project is not deployed from an image"})
+		}
+		if req.Image != nil && strings.TrimSpace(*req.Image) != project.Image {
+			if err := applyImageSource(&project, *req.Image); err != nil {
+				tx.Rollback()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			shouldPull = true
+		}
+		if req.AutoRedeploy != nil {
+			project.AutoRedeploy = *req.AutoRedeploy
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating image: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+	}
+
+	// Update Clone Access (used by the next build, no redeploy)
+	if req.RepoURL != nil && req.Git == nil {
+		tx.Rollback()
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "repoUrl can only change together with git"})
+	}
+	if req.Git != nil {
+		if project.SourceType == "image" {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Project is deployed from an image"})
+		}
+		previousRepoURL := project.RepoURL
+		if req.RepoURL != nil && strings.TrimSpace(*req.RepoURL) != project.RepoURL {
+			// The preview webhook is registered on the current repository
+			if project.Previews {
+				tx.Rollback()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Turn previews off before changing the repository"})
+			}
+			project.RepoURL = strings.TrimSpace(*req.RepoURL)
+		}
+		previousAuth := project.GitAuth
+		cred, err := applyGitAuthRequest(&project, *req.Git)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+
+		// Like on create: the branch the next build clones must exist in the new repository
+		if project.GitAuth == "github" && (previousAuth != "github" || project.RepoURL != previousRepoURL) {
+			token, err := githubToken(userID)
+			if err != nil {
+				tx.Rollback()
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+			}
+			_, err = resolveGithubBranch(c.Request().Context(), token, project.RepoURL, lastBuildBranch(project.ID))
+			switch {
+			case err == nil:
+			case errors.Is(err, errGithubUnauthorized):
+				tx.Rollback()
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			case errors.Is(err, errGithubUnavailable):
+				c.Logger().Warnf("Could not verify %s: %v", project.RepoURL, err)
+			default:
+				tx.Rollback()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+		}
+		if err := saveGitCredential(tx, &project, cred); err != nil {
+			tx.Rollback()
+			fmt.Printf("Error saving git credentials: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating git auth: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+	}
+
+	// Toggle Pull Request Previews (adds or removes the webhook on the GitHub repository)
+	if req.Previews != nil && *req.Previews != project.Previews {
+		if project.SourceType == "image" || project.GitAuth != "github" {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Previews need a GitHub repository cloned with the GitHub login"})
+		}
+		token, err := githubToken(userID)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+		}
+		if *req.Previews {
+			hookID, err := registerPreviewHook(c.Request().Context(), token, &project)
+			if err != nil {
+				tx.Rollback()
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to register webhook: " + err.Error()})
+			}
+			project.PreviewHookID = hookID
+		} else {
+			if err := removePreviewHook(c.Request().Context(), token, &project); err != nil {
+				tx.Rollback()
+				return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to remove webhook: " + err.Error()})
+			}
+			project.PreviewHookID = 0
+			removePreviews = true
+		}
+		project.Previews = *req.Previews
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating previews: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+	}
+
+	// Update Build Settings (used by the next build, no redeploy)
+	if req.Build != nil {
+		if err := applyBuildRequest(&project, *req.Build); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating build settings: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+	}
+
+	// Update Deploy Strategy (used by the next deploy, no redeploy)
+	if req.Strategy != nil {
+		if err := applyStrategyRequest(&project, *req.Strategy); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := checkVolumeScaling(&project); err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := tx.Save(&project).Error; err != nil {
+			tx.Rollback()
+			fmt.Printf("Error updating deploy strategy: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+		}
+	}
+
+	// Update Registry (the image has to be pushed to the new registry first)
+	if req.RegistryID != nil {
+		registryID, err := resolveRegistryID(userID, *req.RegistryID)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if !sameRegistry(project.RegistryID, registryID) {
+			project.RegistryID = registryID
+			if err := tx.Save(&project).Error; err != nil {
+				tx.Rollback()
+				fmt.Printf("Error updating registry: %v\n", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+			}
+			// Image projects only pull with the new credentials
+			if project.SourceType == "image" {
+				shouldPull = true
+			} else {
+				shouldRebuild = true
+			}
+		}
+	}
+
+	// Update Network Allowlist
+	if req.PeerIDs != nil {
+		peers, err := setProjectPeers(tx, &project, req.PeerIDs)
+		if err != nil {
+			tx.Rollback()
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		// The project's own policy changes too; no redeploy needed
+		affectedPeers = append(peers, project.ID)
+	}
+
 	// Update Env Vars
 	if req.EnvVars != nil {
 		// Delete old
@@ -363,6 +800,11 @@ func UpdateProject(c echo.Context) error {
 				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save env vars"})
 			}
 		}
+		if err := recordEnvVersion(tx, "project", project.ID, userID, "", envRequestMap(req.EnvVars)); err != nil {
+			tx.Rollback()
+			fmt.Printf("Error recording env version: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record env history"})
+		}
 		shouldRedeploy = true
 	}
 
@@ -370,42 +812,151 @@ func UpdateProject(c echo.Context) error {
 		fmt.Printf("Transaction commit error: %v\n", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
 	}
+	refreshPeerPolicies(affectedPeers)
+	if removePreviews {
+		deleteProjectPreviews(&project)
+	}
 
-	if shouldRedeploy && k8s.Client != nil {
-		// Fetch Custom Envs
-		var customEnvs []model.ProjectEnv
-		database.DB.Where("project_id = ?", projectID).Find(&customEnvs)
-		
-		// Fetch Reusable Envs
-		var projectEnvs []model.Environment
-		database.DB.Model(&project).Association("Environments").Find(&projectEnvs)
-		
-		envMap := make(map[string]string)
-		
-
-		for _, env := range projectEnvs {
-			var vars []model.EnvironmentVar
-			database.DB.Where("environment_id = ?", env.ID).Find(&vars)
-			for _, v := range vars {
-				envMap[v.Key] = v.Value
-			}
+	if shouldRebuild && k8s.Client != nil {
+		// The build deploys with every other change of this request once it finishes
+		if _, err := enqueueBuild(&project, lastBuildBranch(project.ID)); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to queue build: " + err.Error()})
 		}
+		shouldRedeploy = false
+	}
 
-		// 2. Override with Custom Vars
-		for _, e := range customEnvs {
-			envMap[e.Key] = e.Value
-		}
+	if shouldPull && k8s.Client != nil {
+		pinImageDigest(&project)
+		shouldRedeploy = true
+	}
 
-		// Redeploy
-		if _, err := k8s.DeployProject(projectID, project.OwnerID, project.Name, envMap, project.Port); err != nil {
+	if shouldRedeploy && k8s.Client != nil {
+		if err := redeployProject(&project); err != nil {
 			fmt.Printf("Redeploy error: %v\n", err)
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
 		}
+		// Stages share the project's port, resources, variables and slug
+		redeployStages(&project)
+		if previousSlug != "" {
+			// The new slug may have been redirecting here before
+			if err := k8s.DeleteSlugRedirect(project.OwnerID, project.Slug); err != nil {
+				fmt.Printf("Redirect error: %v\n", err)
+			}
+			if config.App.SlugRedirectGrace > 0 {
+				if err := k8s.ApplySlugRedirect(&project, previousSlug); err != nil {
+					fmt.Printf("Redirect error: %v\n", err)
+				}
+			}
+		}
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Project updated successfully"})
 }
 
+// resolveEnvMap merges the variables of the project's linked environment groups
+// with its custom variables. Custom variables override group variables.
+func resolveEnvMap(project *model.Project) map[string]string {
+	// Fetch Custom Envs
+	var customEnvs []model.ProjectEnv
+	database.DB.Where("project_id = ?", project.ID).Find(&customEnvs)
+
+	// Fetch Reusable Envs
+	var projectEnvs []model.Environment
+	database.DB.Model(project).Association("Environments").Find(&projectEnvs)
+
+	envMap := make(map[string]string)
+	for _, env := range projectEnvs {
+		var vars []model.EnvironmentVar
+		database.DB.Where("environment_id = ?", env.ID).Find(&vars)
+		for _, v := range vars {
+			envMap[v.Key] = v.Value
+		}
+	}
+
+	// Override with Custom Vars
+	for _, e := range customEnvs {
+		envMap[e.Key] = e.Value
+	}
+	return envMap
+}
+
+// applyHealthCheckRequest copies the requested probe settings onto the project and validates them
+func applyHealthCheckRequest(project *model.Project, req model.HealthCheckRequest) error {
+	project.HealthCheck = req.Type
+	if project.HealthCheck == "" {
+		project.HealthCheck = "tcp"
+	}
+	project.HealthPath = req.Path
+	project.HealthInitialDelay = req.InitialDelay
+	project.HealthPeriod = req.Period
+	project.HealthTimeout = req.Timeout
+	project.HealthFailThreshold = req.FailureThreshold
+	return k8s.ValidateHealthCheck(project)
+}
+
+// applyBuildRequest copies the requested build settings onto the project and validates them
+func applyBuildRequest(project *model.Project, req model.BuildRequest) error {
+	project.Dockerfile = strings.TrimSpace(req.Dockerfile)
+	project.ContextSubPath = strings.Trim(strings.TrimSpace(req.ContextSubPath), "/")
+	project.BuildTarget = strings.TrimSpace(req.Target)
+	project.BuildArgs = req.BuildArgs
+	project.BuildTimeout = req.Timeout
+	return k8s.ValidateBuildConfig(project)
+}
+
+// applyStrategyRequest copies the requested rollout strategy onto the project and validates it
+func applyStrategyRequest(project *model.Project, req model.StrategyRequest) error {
+	switch req.Type {
+	case "", "rolling":
+		req.Type = "rolling"
+	case "bluegreen", "canary":
+	default:
+		return fmt.Errorf("strategy must be rolling, bluegreen or canary")
+	}
+	if req.CanaryWeight == 0 {
+		req.CanaryWeight = 20
+	}
+	if req.AnalysisMinutes == 0 {
+		req.AnalysisMinutes = 5
+	}
+	if req.MaxErrorRate == 0 {
+		req.MaxErrorRate = 5
+	}
+	if req.CanaryWeight < 1 || req.CanaryWeight > 99 {
+		return fmt.Errorf("canary weight must be between 1 and 99 percent")
+	}
+	if req.AnalysisMinutes < 1 || req.AnalysisMinutes > 60 {
+		return fmt.Errorf("analysis must last between 1 and 60 minutes")
+	}
+	if req.MaxErrorRate < 1 || req.MaxErrorRate > 100 {
+		return fmt.Errorf("max error rate must be between 1 and 100 percent")
+	}
+	project.DeployStrategy = req.Type
+	project.CanaryWeight = req.CanaryWeight
+	project.AnalysisMinutes = req.AnalysisMinutes
+	project.MaxErrorRate = req.MaxErrorRate
+	return nil
+}
+
+// redeployProject re-applies the project's Deployment with its current configuration.
+// A new version of a blue/green or canary project is rolled out next to the running one instead.
+func redeployProject(project *model.Project) error {
+	envVars := resolveEnvMap(project)
+	supersedeRollouts(project)
+	if changed, previousImage := k8s.NeedsRollout(project, envVars); changed {
+		return startRollout(project, envVars, previousImage)
+	}
+	deployURL, err := k8s.DeployProject(project, envVars)
+	if err != nil {
+		return err
+	}
+	if deployURL != project.DeployURL {
+		project.DeployURL = deployURL
+		database.DB.Model(project).Update("deploy_url", deployURL)
+	}
+	return nil
+}
+
 // GetProjectLogs returns the runtime logs of the project
 func GetProjectLogs(c echo.Context) error {
 	userID := c.Get("userID").(string)
@@ -421,7 +972,7 @@ func GetProjectLogs(c echo.Context) error {
 		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Kubernetes not connected"})
 	}
 
-	logs, err := k8s.GetPodLogs(projectID)
+	logs, err := k8s.GetPodLogs(&project)
 	if err != nil {
 		// Just log error and return empty? Or return error
 		// Often failure means pod is crashlooping or absent
@@ -446,7 +997,7 @@ func GetProjectStats(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{"cpu": "0", "memory": "0"})
 	}
 
-	stats, err := k8s.GetProjectStats(projectID)
+	stats, err := k8s.GetProjectStats(&project)
 	if err != nil {
 		return c.JSON(http.StatusOK, map[string]string{"cpu": "0", "memory": "0"})
 	}
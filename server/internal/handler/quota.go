@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"foundry-server/internal/quota"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// quotaError is returned when an action would exceed the user's quota.
+// It carries the current usage so the client can show what is taking up the quota.
+type quotaError struct {
+	message string
+	usage   model.QuotaUsage
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+// quotaErrorResponse answers 403 with the usage for quota errors, 500 otherwise
+func quotaErrorResponse(c echo.Context, err error) error {
+	if qe, ok := err.(*quotaError); ok {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"error": qe.message,
+			"usage": qe.usage,
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
+
+// userUsage sums what the user currently consumes. Requests of non-stopped projects are
+// reserved once per replica they may scale to. The project excludeID is left out so it
+// can be re-counted with its new settings.
+func userUsage(userID, excludeID string) (model.QuotaUsage, int64, int64, error) {
+	limits := quota.For(userID)
+	quotaCPU, quotaMemory := quota.Resources(limits)
+	quotaStorage := quota.Storage(limits)
+	usage := model.QuotaUsage{
+		MaxProjects:  limits.MaxProjects,
+		CPUQuota:     quotaCPU.String(),
+		MemoryQuota:  quotaMemory.String(),
+		StorageQuota: quotaStorage.String(),
+		MaxBuilds:    limits.MaxBuilds,
+	}
+
+	var projects []model.Project
+	if err := database.DB.Where("owner_id = ?", userID).Find(&projects).Error; err != nil {
+		return usage, 0, 0, fmt.Errorf("failed to load projects: %v", err)
+	}
+
+	var usedMilliCPU, usedMemory int64
+	for i := range projects {
+		p := &projects[i]
+		usage.Projects++
+		if p.ID == excludeID {
+			continue
+		}
+		// Stages and previews have their own scale and keep running while production is stopped
+		n := stageReplicas(p.ID, "") + previewReplicas(p.ID, 0)
+		if p.Status != "stopped" {
+			n += int64(k8s.PeakReplicas(p)) + processReplicas(p.ID, "")
+		}
+		r, err := k8s.EffectiveResources(p)
+		if err != nil {
+			return usage, 0, 0, err
+		}
+		usedMilliCPU += quantityMilli(r.CPURequest) * n
+		usedMemory += quantityValue(r.MemoryRequest) * n
+	}
+	usage.Builds = int(runningBuilds(userID))
+	usage.CPU = resource.NewMilliQuantity(usedMilliCPU, resource.DecimalSI).String()
+	usage.Memory = resource.NewQuantity(usedMemory, resource.BinarySI).String()
+	usage.Storage = resource.NewQuantity(userStorage(userID, ""), resource.BinarySI).String()
+	return usage, usedMilliCPU, usedMemory, nil
+}
+
+// userStorage sums the size of the user's volumes, stopped projects included, leaving out excludeVolumeID
+func userStorage(userID, excludeVolumeID string) int64 {
+	var sizes []string
+	query := database.DB.Model(&model.Volume{}).
+		Joins("JOIN projects ON projects.id = volumes.project_id").
+		Where("projects.owner_id = ?", userID)
+	if excludeVolumeID != "" {
+		query = query.Where("volumes.id <> ?", excludeVolumeID)
+	}
+	query.Pluck("volumes.size", &sizes)
+
+	var total int64
+	for _, s := range sizes {
+		if q, err := resource.ParseQuantity(s); err == nil {
+			total += q.Value()
+		}
+	}
+	return total
+}
+
+// checkStorageQuota verifies that a volume of size fits next to the owner's other volumes.
+// excludeVolumeID leaves out the volume being resized.
+func checkStorageQuota(project *model.Project, size resource.Quantity, excludeVolumeID string) error {
+	used := userStorage(project.OwnerID, excludeVolumeID)
+	quotaStorage := quota.Storage(quota.For(project.OwnerID))
+	if used+size.Value() > quotaStorage.Value() {
+		usage, _, _, err := userUsage(project.OwnerID, "")
+		if err != nil {
+			return err
+		}
+		return &quotaError{fmt.Sprintf("storage quota exceeded: %s already in use, %s requested, quota is %s",
+			resource.NewQuantity(used, resource.BinarySI).String(), size.String(), quotaStorage.String()), usage}
+	}
+	return nil
+}
+
+// stageReplicas sums the replicas of the project's deployed stages, leaving out excludeStageID
+func stageReplicas(projectID, excludeStageID string) int64 {
+	if projectID == "" {
+		return 0
+	}
+	var total int64
+	query := database.DB.Model(&model.Stage{}).Where("project_id = ? AND image <> ''", projectID)
+	if excludeStageID != "" {
+		query = query.Where("id <> ?", excludeStageID)
+	}
+	query.Select("COALESCE(SUM(replicas), 0)").Scan(&total)
+	return total
+}
+
+// previewReplicas counts the project's building or running previews, one replica each,
+// leaving out the preview of pull request excludeNumber
+func previewReplicas(projectID string, excludeNumber int) int64 {
+	if projectID == "" {
+		return 0
+	}
+	var count int64
+	database.DB.Model(&model.Preview{}).
+		Where("project_id = ? AND number <> ? AND status IN ?", projectID, excludeNumber, []string{"building", "running"}).
+		Count(&count)
+	return count
+}
+
+// processReplicas sums the pods the project's process types may run at once, leaving out
+// excludeName: the replicas of each worker, and one per scheduled cron (runs never overlap)
+func processReplicas(projectID, excludeName string) int64 {
+	if projectID == "" {
+		return 0
+	}
+	var processes []model.Process
+	query := database.DB.Where("project_id = ?", projectID)
+	if excludeName != "" {
+		query = query.Where("name <> ?", excludeName)
+	}
+	query.Find(&processes)
+	return processesReplicas(processes)
+}
+
+func processesReplicas(processes []model.Process) int64 {
+	var total int64
+	for _, p := range processes {
+		if p.Type == "cron" {
+			if p.Replicas > 0 {
+				total++
+			}
+			continue
+		}
+		total += int64(p.Replicas)
+	}
+	return total
+}
+
+// checkResourceQuota verifies that the candidate project, together with the owner's
+// other non-stopped projects, fits within the per-user CPU/memory request quota.
+// Requests are counted once per replica the project, its process types, stages and previews may scale to.
+func checkResourceQuota(candidate *model.Project) error {
+	n := int64(k8s.PeakReplicas(candidate)) + processReplicas(candidate.ID, "") + stageReplicas(candidate.ID, "") +
+		previewReplicas(candidate.ID, 0)
+	return checkReplicaQuota(candidate, n)
+}
+
+// checkStageQuota verifies the stage still fits within the quota with its new replica count
+func checkStageQuota(project *model.Project, stage *model.Stage) error {
+	n := int64(stage.Replicas) + stageReplicas(project.ID, stage.ID) + previewReplicas(project.ID, 0)
+	if project.Status != "stopped" {
+		n += int64(k8s.PeakReplicas(project)) + processReplicas(project.ID, "")
+	}
+	return checkReplicaQuota(project, n)
+}
+
+// checkProcessQuota verifies the project still fits within the quota running processes.
+// Process types of a stopped project only count once it is started again.
+func checkProcessQuota(project *model.Project, processes []model.Process) error {
+	if project.Status == "stopped" {
+		return nil
+	}
+	n := int64(k8s.PeakReplicas(project)) + processesReplicas(processes) + stageReplicas(project.ID, "") +
+		previewReplicas(project.ID, 0)
+	return checkReplicaQuota(project, n)
+}
+
+// checkPreviewQuota verifies the preview of pull request number fits next to the project's
+// other replicas and the owner's other projects
+func checkPreviewQuota(project *model.Project, number int) error {
+	n := 1 + previewReplicas(project.ID, number) + stageReplicas(project.ID, "")
+	if project.Status != "stopped" {
+		n += int64(k8s.PeakReplicas(project)) + processReplicas(project.ID, "")
+	}
+	return checkReplicaQuota(project, n)
+}
+
+// checkReplicaQuota verifies that n replicas of the project fit next to the owner's other projects
+func checkReplicaQuota(candidate *model.Project, n int64) error {
+	usage, usedMilliCPU, usedMemory, err := userUsage(candidate.OwnerID, candidate.ID)
+	if err != nil {
+		return err
+	}
+
+	r, err := k8s.EffectiveResources(candidate)
+	if err != nil {
+		return err
+	}
+	wantMilliCPU := quantityMilli(r.CPURequest) * n
+	wantMemory := quantityValue(r.MemoryRequest) * n
+
+	quotaCPU, quotaMemory := quota.Resources(quota.For(candidate.OwnerID))
+	if usedMilliCPU+wantMilliCPU > quotaCPU.MilliValue() {
+		return &quotaError{fmt.Sprintf("CPU quota exceeded: %s already in use, %s requested, quota is %s",
+			usage.CPU, resource.NewMilliQuantity(wantMilliCPU, resource.DecimalSI).String(), usage.CPUQuota), usage}
+	}
+	if usedMemory+wantMemory > quotaMemory.Value() {
+		return &quotaError{fmt.Sprintf("memory quota exceeded: %s already in use, %s requested, quota is %s",
+			usage.Memory, resource.NewQuantity(wantMemory, resource.BinarySI).String(), usage.MemoryQuota), usage}
+	}
+	return nil
+}
+
+// checkProjectQuota verifies the user may create another project
+func checkProjectQuota(userID string) error {
+	usage, _, _, err := userUsage(userID, "")
+	if err != nil {
+		return err
+	}
+	if usage.Projects >= usage.MaxProjects {
+		return &quotaError{fmt.Sprintf("project limit reached: %d of %d projects in use", usage.Projects, usage.MaxProjects), usage}
+	}
+	return nil
+}
+
+// GetMyQuota returns the user's current usage and limits
+func GetMyQuota(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	usage, _, _, err := userUsage(userID, "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+// GetUserQuota returns a user's quota override and current usage (admin only)
+func GetUserQuota(c echo.Context) error {
+	targetID := c.Param("id")
+
+	var user model.User
+	if err := database.DB.First(&user, "id = ?", targetID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	override := model.UserQuota{UserID: user.ID}
+	database.DB.Where("user_id = ?", user.ID).First(&override)
+
+	usage, _, _, err := userUsage(user.ID, "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"override": override,
+		"usage":    usage,
+	})
+}
+
+// SetUserQuota stores a user's quota override (admin only). Zero values reset to the default.
+// Existing projects above the new quota keep running; only new reservations are rejected.
+func SetUserQuota(c echo.Context) error {
+	targetID := c.Param("id")
+
+	var req model.UserQuota
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var user model.User
+	if err := database.DB.First(&user, "id = ?", targetID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	if req.MaxProjects < 0 || req.MaxBuilds < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Limits must not be negative"})
+	}
+	for _, q := range []string{req.CPU, req.Memory, req.Storage} {
+		if q == "" {
+			continue
+		}
+		if v, err := resource.ParseQuantity(q); err != nil || v.Sign() <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Invalid quantity %q", q)})
+		}
+	}
+
+	req.UserID = user.ID
+	if err := database.DB.Save(&req).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save quota"})
+	}
+
+	// Resize the namespace's ResourceQuota to match
+	if k8s.Client != nil {
+		if _, err := k8s.EnsureUserNamespace(user.ID); err != nil {
+			c.Logger().Errorf("Failed to update namespace quota for user %s: %v", user.ID, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, req)
+}
+
+func quantityMilli(s string) int64 {
+	q := resource.MustParse(s)
+	return q.MilliValue()
+}
+
+func quantityValue(s string) int64 {
+	q := resource.MustParse(s)
+	return q.Value()
+}
+
+// applyScalingRequest copies the requested scaling policy onto the project and validates it
+func applyScalingRequest(project *model.Project, req model.ScalingRequest) error {
+	limit := config.App.UserMaxReplicas
+
+	if !req.Autoscale {
+		if req.Replicas < 1 || req.Replicas > limit {
+			return fmt.Errorf("replicas must be between 1 and %d", limit)
+		}
+		project.Autoscale = false
+		project.Replicas = req.Replicas
+		return nil
+	}
+
+	if req.TargetCPU == 0 {
+		req.TargetCPU = 80
+	}
+	if req.MinReplicas < 1 || req.MinReplicas > req.MaxReplicas {
+		return fmt.Errorf("minReplicas must be at least 1 and not greater than maxReplicas")
+	}
+	if req.MaxReplicas > limit {
+		return fmt.Errorf("maxReplicas must not exceed %d", limit)
+	}
+	if req.TargetCPU < 10 || req.TargetCPU > 100 {
+		return fmt.Errorf("targetCpu must be between 10 and 100")
+	}
+	project.Autoscale = true
+	project.MinReplicas = req.MinReplicas
+	project.MaxReplicas = req.MaxReplicas
+	project.TargetCPU = req.TargetCPU
+	return nil
+}
+
+// applyResourceRequest copies the requested sizing onto the project and validates it
+func applyResourceRequest(project *model.Project, req model.ResourceRequest) error {
+	project.Size = req.Size
+	if req.Size == "custom" {
+		project.CPURequest = req.CPURequest
+		project.CPULimit = req.CPULimit
+		project.MemoryRequest = req.MemoryRequest
+		project.MemoryLimit = req.MemoryLimit
+	}
+	return k8s.ApplySize(project)
+}
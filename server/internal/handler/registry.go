@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var registryServerPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?(:[0-9]{1,5})?$`)
+
+// applyRegistryRequest validates the request and copies it onto registry.
+// An empty password keeps the stored one.
+func applyRegistryRequest(registry *model.Registry, req model.RegistryRequest) error {
+	server := strings.ToLower(strings.TrimSpace(req.Server))
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server = strings.TrimSuffix(server, "/")
+	if !registryServerPattern.MatchString(server) {
+		return fmt.Errorf("invalid registry server")
+	}
+	if strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Username) == "" {
+		return fmt.Errorf("name and username are required")
+	}
+
+	prefix := strings.TrimSuffix(strings.TrimSpace(req.ImagePrefix), "/")
+	if prefix == "" {
+		prefix = server + "/" + strings.ToLower(req.Username)
+	}
+	if !strings.HasPrefix(prefix, server+"/") {
+		return fmt.Errorf("imagePrefix must start with %s/", server)
+	}
+
+	registry.Name = strings.TrimSpace(req.Name)
+	registry.Server = server
+	registry.ImagePrefix = prefix
+	registry.Username = strings.TrimSpace(req.Username)
+	if req.Password != "" {
+		registry.Password = req.Password
+	}
+	if registry.Password == "" {
+		return fmt.Errorf("password is required")
+	}
+	return nil
+}
+
+// resolveRegistryID checks that the registry belongs to the user. An empty ID selects the platform registry.
+func resolveRegistryID(userID, registryID string) (*string, error) {
+	if registryID == "" {
+		return nil, nil
+	}
+	var registry model.Registry
+	if err := database.DB.Select("id").Where("id = ? AND owner_id = ?", registryID, userID).First(&registry).Error; err != nil {
+		return nil, fmt.Errorf("registry not found")
+	}
+	return &registry.ID, nil
+}
+
+func sameRegistry(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// GetRegistries lists the user's private registries (without passwords)
+func GetRegistries(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	registries := []model.Registry{}
+	if err := database.DB.Where("owner_id = ?", userID).Order("created_at").Find(&registries).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch registries"})
+	}
+	return c.JSON(http.StatusOK, registries)
+}
+
+// CreateRegistry stores private registry credentials
+func CreateRegistry(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	var req model.RegistryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	registry := model.Registry{OwnerID: userID}
+	if err := applyRegistryRequest(&registry, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := database.DB.Create(&registry).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save registry"})
+	}
+	return c.JSON(http.StatusCreated, registry)
+}
+
+// UpdateRegistry changes a registry's settings or rotates its credentials.
+// Projects using it get their docker-config Secrets rewritten.
+func UpdateRegistry(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	id := c.Param("id")
+
+	var req model.RegistryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var registry model.Registry
+	if err := database.DB.Where("id = ? AND owner_id = ?", id, userID).First(&registry).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Registry not found"})
+	}
+	if err := applyRegistryRequest(&registry, req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := database.DB.Save(&registry).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save registry"})
+	}
+
+	k8s.RefreshRegistrySecrets(registry.ID)
+	return c.JSON(http.StatusOK, registry)
+}
+
+// DeleteRegistry removes registry credentials that no project uses anymore
+func DeleteRegistry(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	id := c.Param("id")
+
+	var registry model.Registry
+	if err := database.DB.Where("id = ? AND owner_id = ?", id, userID).First(&registry).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Registry not found"})
+	}
+
+	var inUse int64
+	database.DB.Model(&model.Project{}).Where("registry_id = ?", registry.ID).Count(&inUse)
+	if inUse > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("Registry is used by %d project(s)", inUse)})
+	}
+
+	if err := database.DB.Delete(&registry).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete registry"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Registry deleted"})
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/metrics"
+	"foundry-server/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+var activeRolloutPhases = []string{"starting", "analyzing", "promoting"}
+
+// startRollout runs the new version next to the running one. The rollout controller
+// takes it from there; the project keeps serving the old version in the meantime.
+func startRollout(project *model.Project, envVars map[string]string, previousImage string) error {
+	rollout := model.Rollout{
+		ProjectID:     project.ID,
+		Strategy:      project.DeployStrategy,
+		Image:         k8s.DeployImage(project),
+		PreviousImage: previousImage,
+		Phase:         "starting",
+	}
+	if err := database.DB.Create(&rollout).Error; err != nil {
+		return fmt.Errorf("failed to record rollout: %v", err)
+	}
+	if err := k8s.StartRollout(project, envVars); err != nil {
+		endRollout(project, &rollout, "starting", "failed", err.Error())
+		return err
+	}
+	return nil
+}
+
+// endRollout claims the rollout out of phase and sends all traffic back to the stable Deployment.
+// It returns false when another replica moved the rollout on first.
+func endRollout(project *model.Project, rollout *model.Rollout, phase, result, reason string) bool {
+	now := time.Now()
+	res := database.DB.Model(&model.Rollout{}).
+		Where("id = ? AND phase = ?", rollout.ID, phase).
+		Updates(map[string]interface{}{"phase": result, "reason": reason, "finished_at": &now})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return false
+	}
+	if err := k8s.FinishRollout(project); err != nil {
+		fmt.Printf("[Rollout] Cleanup of %s failed: %v\n", project.Name, err)
+	}
+	return true
+}
+
+// rollBack ends the rollout with the old version still serving. Git projects go back
+// to the previous release so the next deploy doesn't pick the rejected one up again.
+// Image projects keep the rejected digest pinned, so the image watcher doesn't retry it
+// until the tag moves on.
+func rollBack(project *model.Project, rollout *model.Rollout, reason string) {
+	if !endRollout(project, rollout, rollout.Phase, "rolled_back", reason) {
+		return
+	}
+	fmt.Printf("[Rollout] Rolled back %s: %s\n", project.Name, reason)
+	if project.SourceType != "image" {
+		res := database.DB.Model(&model.Project{}).
+			Where("id = ? AND release_image = ?", project.ID, rollout.Image).
+			Update("release_image", rollout.PreviousImage)
+		if res.Error == nil && res.RowsAffected == 1 {
+			project.ReleaseImage = rollout.PreviousImage
+		}
+	}
+}
+
+// abortRollouts rolls back the project's active rollout, if any
+func abortRollouts(project *model.Project, reason string) {
+	var rollouts []model.Rollout
+	database.DB.Where("project_id = ? AND phase IN ?", project.ID, activeRolloutPhases).Find(&rollouts)
+	for i := range rollouts {
+		rollBack(project, &rollouts[i], reason)
+	}
+}
+
+// supersedeRollouts ends the project's active rollout without touching its release,
+// because a newer deploy is about to replace the version it was rolling out
+func supersedeRollouts(project *model.Project) {
+	var rollouts []model.Rollout
+	database.DB.Where("project_id = ? AND phase IN ?", project.ID, activeRolloutPhases).Find(&rollouts)
+	for i := range rollouts {
+		endRollout(project, &rollouts[i], rollouts[i].Phase, "rolled_back", "superseded by another deploy")
+	}
+}
+
+// StartRolloutController advances blue/green and canary rollouts: it switches traffic once
+// the new version is ready, watches it for the project's analysis window, then promotes it
+// or rolls it back. Phases are claimed in the database, so any replica can advance a rollout.
+func StartRolloutController() {
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if database.DB == nil || k8s.Client == nil {
+				continue
+			}
+
+			var rollouts []model.Rollout
+			database.DB.Where("phase IN ?", activeRolloutPhases).Find(&rollouts)
+			for i := range rollouts {
+				advanceRollout(&rollouts[i])
+			}
+		}
+	}()
+}
+
+func advanceRollout(rollout *model.Rollout) {
+	var project model.Project
+	if err := database.DB.First(&project, "id = ?", rollout.ProjectID).Error; err != nil {
+		now := time.Now()
+		database.DB.Model(rollout).Updates(map[string]interface{}{"phase": "failed", "reason": "project not found", "finished_at": &now})
+		return
+	}
+	elapsed := time.Since(rollout.UpdatedAt)
+
+	switch rollout.Phase {
+	case "starting":
+		health, reason, err := k8s.RolloutHealth(&project)
+		if err != nil {
+			fmt.Printf("[Rollout] Error checking %s: %v\n", project.Name, err)
+			return
+		}
+		switch {
+		case health == "failed":
+			rollBack(&project, rollout, "new version failed to start: "+reason)
+		case health == "ready":
+			if !claimRolloutPhase(rollout, "analyzing") {
+				return
+			}
+			if err := k8s.SwitchTraffic(&project); err != nil {
+				rollBack(&project, rollout, "failed to switch traffic: "+err.Error())
+				return
+			}
+			fmt.Printf("[Rollout] New version of %s is taking traffic (%s)\n", project.Name, rollout.Strategy)
+		case elapsed > config.App.RolloutTimeout:
+			rollBack(&project, rollout, fmt.Sprintf("new version not ready after %s: %s", config.App.RolloutTimeout, reason))
+		}
+
+	case "analyzing":
+		health, reason, err := k8s.RolloutHealth(&project)
+		if err != nil {
+			fmt.Printf("[Rollout] Error checking %s: %v\n", project.Name, err)
+			return
+		}
+		if health != "ready" {
+			rollBack(&project, rollout, "new version lost readiness: "+reason)
+			return
+		}
+		if rate, ok := rolloutErrorRate(&project, rollout); ok && rate*100 > float64(project.MaxErrorRate) {
+			rollBack(&project, rollout, fmt.Sprintf("error rate %.1f%% above %d%%", rate*100, project.MaxErrorRate))
+			return
+		}
+		if elapsed < time.Duration(project.AnalysisMinutes)*time.Minute {
+			return
+		}
+		if !claimRolloutPhase(rollout, "promoting") {
+			return
+		}
+		if err := k8s.PromoteRollout(&project, resolveEnvMap(&project)); err != nil {
+			// The stable Deployment may be half updated already, so there is nothing to roll back to
+			endRollout(&project, rollout, "promoting", "failed", "failed to promote: "+err.Error())
+			return
+		}
+		fmt.Printf("[Rollout] Promoting new version of %s\n", project.Name)
+
+	case "promoting":
+		ready, err := k8s.StableReady(&project)
+		if err != nil {
+			fmt.Printf("[Rollout] Error checking %s: %v\n", project.Name, err)
+			return
+		}
+		if ready {
+			if endRollout(&project, rollout, "promoting", "succeeded", "") {
+				fmt.Printf("[Rollout] %s is running the new version\n", project.Name)
+			}
+		} else if elapsed > config.App.RolloutTimeout {
+			// The stable Deployment finishes updating on its own; it just does so in place
+			endRollout(&project, rollout, "promoting", "failed", fmt.Sprintf("deployment not updated after %s", config.App.RolloutTimeout))
+		}
+	}
+}
+
+// claimRolloutPhase moves the rollout to its next phase and restarts the phase clock
+func claimRolloutPhase(rollout *model.Rollout, next string) bool {
+	res := database.DB.Model(&model.Rollout{}).
+		Where("id = ? AND phase = ?", rollout.ID, rollout.Phase).
+		Updates(map[string]interface{}{"phase": next, "updated_at": time.Now()})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return false
+	}
+	rollout.Phase = next
+	return true
+}
+
+// rolloutErrorRate measures the share of 5xx responses of the new version: all requests
+// to the project's Service for blue/green, the canary's own Service for canary
+func rolloutErrorRate(project *model.Project, rollout *model.Rollout) (float64, bool) {
+	service := k8s.ResourceName(project)
+	if rollout.Strategy == "canary" {
+		service = k8s.RolloutName(project)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	rate, ok, err := metrics.ErrorRate(ctx, k8s.UserNamespace(project.OwnerID), service)
+	if err != nil {
+		fmt.Printf("[Rollout] Error rate of %s unavailable: %v\n", project.Name, err)
+		return 0, false
+	}
+	return rate, ok
+}
+
+// GetProjectRollouts lists the project's recent blue/green and canary rollouts
+func GetProjectRollouts(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	rollouts := []model.Rollout{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("created_at DESC").Limit(50).Find(&rollouts).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch rollouts"})
+	}
+	return c.JSON(http.StatusOK, rollouts)
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	slugPattern     = regexp.MustCompile(`^[a-z]([a-z0-9-]*[a-z0-9])?$`)
+	slugInvalid     = regexp.MustCompile(`[^a-z0-9]+`)
+	uuidLikePattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// Pull request previews are served as pr-<number>-<slug>
+	previewLikePattern = regexp.MustCompile(`^pr-[0-9]+(-|$)`)
+)
+
+// maxSlugLength keeps "<slug><suffix>" within the 63 character DNS label limit
+func maxSlugLength() int {
+	return 63 - len(config.App.HostSuffix)
+}
+
+// slugify turns a project name into a DNS-safe label (e.g. "My Cool App!" -> "my-cool-app")
+func slugify(name string) string {
+	slug := slugInvalid.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+	if slug == "" {
+		slug = "app"
+	}
+	// Service names must start with a letter, and pr-<number>-... is left to previews
+	if slug[0] < 'a' || slug[0] > 'z' || previewLikePattern.MatchString(slug) {
+		slug = "app-" + slug
+	}
+	if len(slug) > maxSlugLength() {
+		slug = strings.TrimRight(slug[:maxSlugLength()], "-")
+	}
+	return slug
+}
+
+// validateSlug checks a user-chosen slug
+func validateSlug(slug string) error {
+	if len(slug) > maxSlugLength() || !slugPattern.MatchString(slug) {
+		return fmt.Errorf("slug must start with a letter, contain only lowercase letters, digits and '-', and be at most %d characters", maxSlugLength())
+	}
+	// Legacy projects are still served under their ID
+	if uuidLikePattern.MatchString(slug) {
+		return fmt.Errorf("slug must not look like a project ID")
+	}
+	if previewLikePattern.MatchString(slug) {
+		return fmt.Errorf("slug must not look like a pull request preview (pr-<number>-...)")
+	}
+	return nil
+}
+
+// slugAvailable reports whether no other project uses the slug, currently, as a pending redirect,
+// as the host of one of its stages (<slug>-<stage name>) or for the resources of a rollout
+func slugAvailable(db *gorm.DB, slug, projectID string) bool {
+	var count int64
+	query := db.Model(&model.Project{}).Where("slug = ?", slug)
+	if projectID != "" {
+		query = query.Where("id <> ?", projectID)
+	}
+	query.Count(&count)
+	if count > 0 {
+		return false
+	}
+
+	for _, suffix := range k8s.RolloutSuffixes {
+		query = db.Model(&model.Project{}).Where("slug <> '' AND slug || ? = ?", "-"+suffix, slug)
+		if projectID != "" {
+			query = query.Where("id <> ?", projectID)
+		}
+		query.Count(&count)
+		if count > 0 {
+			return false
+		}
+	}
+
+	query = db.Model(&model.Stage{}).
+		Joins("JOIN projects ON projects.id = stages.project_id").
+		Where("projects.slug || '-' || stages.name = ?", slug)
+	if projectID != "" {
+		query = query.Where("stages.project_id <> ?", projectID)
+	}
+	query.Count(&count)
+	if count > 0 {
+		return false
+	}
+
+	// Process types share the namespace, so their names can't collide with other hosts
+	query = db.Model(&model.Process{}).
+		Joins("JOIN projects ON projects.id = processes.project_id").
+		Where("projects.slug || '-' || processes.name = ?", slug)
+	if projectID != "" {
+		query = query.Where("processes.project_id <> ?", projectID)
+	}
+	query.Count(&count)
+	if count > 0 {
+		return false
+	}
+
+	query = db.Model(&model.SlugRedirect{}).Where("slug = ? AND expires_at > ?", slug, time.Now())
+	if projectID != "" {
+		query = query.Where("project_id <> ?", projectID)
+	}
+	query.Count(&count)
+	return count == 0
+}
+
+// isRolloutSuffix reports whether a stage or process name would take a rollout's resource names
+func isRolloutSuffix(name string) bool {
+	for _, suffix := range k8s.RolloutSuffixes {
+		if name == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// rolloutNamesAvailable reports whether the names a rollout of a project with the slug
+// would use are free
+func rolloutNamesAvailable(db *gorm.DB, slug, projectID string) bool {
+	for _, suffix := range k8s.RolloutSuffixes {
+		if !slugAvailable(db, slug+"-"+suffix, projectID) {
+			return false
+		}
+	}
+	return true
+}
+
+// generateSlug derives a unique slug from the project name, adding a numeric suffix on collision
+func generateSlug(db *gorm.DB, name string) string {
+	base := slugify(name)
+	if slugAvailable(db, base, "") && rolloutNamesAvailable(db, base, "") {
+		return base
+	}
+	// "pr" with a numeric suffix would look like a preview
+	if previewLikePattern.MatchString(base + "-2") {
+		base = "app-" + base
+	}
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		candidate := base
+		if len(candidate)+len(suffix) > maxSlugLength() {
+			candidate = strings.TrimRight(candidate[:maxSlugLength()-len(suffix)], "-")
+		}
+		candidate += suffix
+		if slugAvailable(db, candidate, "") && rolloutNamesAvailable(db, candidate, "") {
+			return candidate
+		}
+	}
+}
+
+// renameProjectSlug changes the project's slug inside tx and reserves the previous one
+// as a redirect for the configured grace period. It returns the previous slug.
+func renameProjectSlug(tx *gorm.DB, project *model.Project, slug string) (string, error) {
+	if err := validateSlug(slug); err != nil {
+		return "", err
+	}
+	if !slugAvailable(tx, slug, project.ID) || !rolloutNamesAvailable(tx, slug, project.ID) {
+		return "", fmt.Errorf("slug %q is already taken", slug)
+	}
+	// The project's stages move to the new slug as well
+	var stages []model.Stage
+	tx.Where("project_id = ?", project.ID).Find(&stages)
+	for _, s := range stages {
+		host := slug + "-" + s.Name
+		if len(host) > maxSlugLength() {
+			return "", fmt.Errorf("slug is too long for stage %s", s.Name)
+		}
+		if !slugAvailable(tx, host, project.ID) {
+			return "", fmt.Errorf("host %s of stage %s is already taken", host, s.Name)
+		}
+	}
+	var processes []model.Process
+	tx.Where("project_id = ?", project.ID).Find(&processes)
+	for _, p := range processes {
+		name := slug + "-" + p.Name
+		if len(name) > maxProcessNameLength(&p) {
+			return "", fmt.Errorf("slug is too long for process %s", p.Name)
+		}
+		if !slugAvailable(tx, name, project.ID) {
+			return "", fmt.Errorf("name %s of process %s is already taken", name, p.Name)
+		}
+	}
+
+	oldSlug := k8s.ResourceName(project)
+
+	// Renaming back to a previous slug takes it out of the redirect list
+	if err := tx.Where("slug = ?", slug).Delete(&model.SlugRedirect{}).Error; err != nil {
+		return "", err
+	}
+	if config.App.SlugRedirectGrace > 0 {
+		redirect := model.SlugRedirect{
+			Slug:      oldSlug,
+			ProjectID: project.ID,
+			ExpiresAt: time.Now().Add(config.App.SlugRedirectGrace),
+		}
+		if err := tx.Save(&redirect).Error; err != nil {
+			return "", err
+		}
+	}
+
+	project.Slug = slug
+	if err := tx.Save(project).Error; err != nil {
+		return "", err
+	}
+	return oldSlug, nil
+}
+
+// StartSlugRedirectCleanup periodically removes redirects whose grace period is over
+func StartSlugRedirectCleanup() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for ; ; <-ticker.C {
+			if database.DB == nil {
+				continue
+			}
+			var expired []model.SlugRedirect
+			database.DB.Where("expires_at <= ?", time.Now()).Find(&expired)
+			for _, r := range expired {
+				var project model.Project
+				database.DB.Select("owner_id").Where("id = ?", r.ProjectID).First(&project)
+				if err := k8s.DeleteSlugRedirect(project.OwnerID, r.Slug); err != nil {
+					fmt.Printf("[Slug] Failed to remove redirect %s: %v\n", r.Slug, err)
+					continue
+				}
+				database.DB.Delete(&r)
+				fmt.Printf("[Slug] Redirect for %s expired\n", r.Slug)
+			}
+		}
+	}()
+}
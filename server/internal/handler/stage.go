@@ -0,0 +1,541 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxStageNameLength keeps stage names short enough to leave room for the project slug in the host
+const maxStageNameLength = 20
+
+var stageNamePattern = regexp.MustCompile(`^[a-z]([a-z0-9-]*[a-z0-9])?$`)
+
+// validateStageName checks a stage name and that its host is free
+func validateStageName(db *gorm.DB, project *model.Project, name string) error {
+	if len(name) > maxStageNameLength || !stageNamePattern.MatchString(name) {
+		return fmt.Errorf("stage name must start with a letter, contain only lowercase letters, digits and '-', and be at most %d characters", maxStageNameLength)
+	}
+	// Production is the project itself; rollouts use the project's slug with their own suffixes
+	if name == "production" || isRolloutSuffix(name) {
+		return fmt.Errorf("stage name %q is reserved", name)
+	}
+	host := k8s.StageName(project, &model.Stage{Name: name})
+	if len(host) > maxSlugLength() {
+		return fmt.Errorf("stage name is too long for the project slug")
+	}
+	if !slugAvailable(db, host, "") {
+		return fmt.Errorf("host %s is already taken", host)
+	}
+	return nil
+}
+
+// applyStageReplicas validates a stage's replica count; 0 keeps the stage stopped
+func applyStageReplicas(stage *model.Stage, replicas int) error {
+	if replicas < 0 || replicas > config.App.UserMaxReplicas {
+		return fmt.Errorf("replicas must be between 0 and %d", config.App.UserMaxReplicas)
+	}
+	stage.Replicas = replicas
+	return nil
+}
+
+// saveStageEnv replaces the stage's env overrides inside tx
+func saveStageEnv(tx *gorm.DB, stage *model.Stage, envVars []model.EnvVarRequest) error {
+	if err := tx.Where("stage_id = ?", stage.ID).Delete(&model.StageEnv{}).Error; err != nil {
+		return err
+	}
+	for _, env := range envVars {
+		if env.Key == "" {
+			continue
+		}
+		if err := tx.Create(&model.StageEnv{StageID: stage.ID, Key: env.Key, Value: env.Value}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageEnvMap is the project's env map with the stage's overrides applied
+func stageEnvMap(project *model.Project, stage *model.Stage) map[string]string {
+	envMap := resolveEnvMap(project)
+	var overrides []model.StageEnv
+	database.DB.Where("stage_id = ?", stage.ID).Find(&overrides)
+	for _, e := range overrides {
+		envMap[e.Key] = e.Value
+	}
+	return envMap
+}
+
+// deployStage applies the stage's current release and records the outcome on the stage
+func deployStage(project *model.Project, stage *model.Stage) error {
+	database.DB.Model(stage).Update("status", "deploying")
+	deployURL, err := k8s.DeployStage(project, stage, stageEnvMap(project, stage))
+	if err != nil {
+		database.DB.Model(stage).Update("status", "error")
+		return err
+	}
+	stage.DeployURL = deployURL
+	database.DB.Model(stage).Updates(map[string]interface{}{"status": "running", "deploy_url": deployURL})
+	return nil
+}
+
+// redeployStages re-applies every deployed stage of the project, e.g. after its
+// port, resources, variables or slug changed
+func redeployStages(project *model.Project) {
+	var stages []model.Stage
+	database.DB.Where("project_id = ? AND image <> ''", project.ID).Find(&stages)
+	for i := range stages {
+		if err := deployStage(project, &stages[i]); err != nil {
+			fmt.Printf("[Stage] Deploy failed for %s of %s: %v\n", stages[i].Name, project.Name, err)
+		}
+	}
+}
+
+// enqueueStageBuild queues a build of the stage's branch. It deploys to the stage only.
+func enqueueStageBuild(project *model.Project, stage *model.Stage) (*model.Build, error) {
+	build := model.Build{
+		ProjectID: project.ID,
+		Branch:    stage.Branch,
+		StageID:   &stage.ID,
+		Status:    "queued",
+	}
+	if err := database.DB.Create(&build).Error; err != nil {
+		return nil, err
+	}
+	database.DB.Model(stage).Update("status", "building")
+	kickBuildQueue()
+	return &build, nil
+}
+
+// finishStageBuild records a finished stage build as the stage's release and deploys it.
+// A failed or cancelled build leaves the previous release running.
+func finishStageBuild(project *model.Project, build *model.Build, buildStatus string) {
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", *build.StageID, project.ID).First(&stage).Error; err != nil {
+		return // Stage deleted in the meantime
+	}
+
+	if buildStatus != "succeeded" {
+		status := "error"
+		if buildStatus == "cancelled" && stage.Image != "" {
+			status = "running"
+		}
+		database.DB.Model(&stage).Update("status", status)
+		return
+	}
+
+	release := model.Release{
+		ProjectID: project.ID,
+		StageID:   &stage.ID,
+		BuildID:   &build.ID,
+		Image:     k8s.ReleaseImageName(project, build),
+		Branch:    build.Branch,
+	}
+	if err := database.DB.Create(&release).Error; err != nil {
+		fmt.Printf("[Stage] Failed to record release of %s: %v\n", stage.Name, err)
+	}
+	stage.Image = release.Image
+	database.DB.Model(&stage).Update("image", release.Image)
+	if err := deployStage(project, &stage); err != nil {
+		fmt.Printf("[Stage] Deploy failed for %s of %s: %v\n", stage.Name, project.Name, err)
+	}
+}
+
+// releaseProductionBuild makes a finished production build the project's release
+func releaseProductionBuild(project *model.Project, build *model.Build) {
+	release := model.Release{
+		ProjectID: project.ID,
+		BuildID:   &build.ID,
+		Image:     k8s.ReleaseImageName(project, build),
+		Branch:    build.Branch,
+	}
+	if err := database.DB.Create(&release).Error; err != nil {
+		fmt.Printf("[Build] Failed to record release of %s: %v\n", project.Name, err)
+	}
+	project.ReleaseImage = release.Image
+	database.DB.Model(project).Update("release_image", release.Image)
+}
+
+// deleteProjectStages removes every stage of the project, its variables and its deployment.
+// Releases are kept as history until the project itself is deleted.
+func deleteProjectStages(project *model.Project) {
+	var stages []model.Stage
+	database.DB.Where("project_id = ?", project.ID).Find(&stages)
+	for i := range stages {
+		if err := k8s.DeleteStage(project, &stages[i]); err != nil {
+			fmt.Printf("[Stage] %v\n", err)
+		}
+		database.DB.Where("stage_id = ?", stages[i].ID).Delete(&model.StageEnv{})
+		database.DB.Delete(&stages[i])
+	}
+}
+
+// GetProjectStages lists the project's stages with their env overrides
+func GetProjectStages(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	stages := []model.Stage{}
+	if err := database.DB.Preload("EnvVars").Where("project_id = ?", project.ID).Order("created_at").Find(&stages).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch stages"})
+	}
+	return c.JSON(http.StatusOK, stages)
+}
+
+// CreateProjectStage adds a stage tracking a branch and queues its first build
+func CreateProjectStage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var req model.StageRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+	if project.SourceType == "image" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Stages need a project built from git"})
+	}
+
+	stage := model.Stage{
+		ProjectID: project.ID,
+		Name:      strings.TrimSpace(req.Name),
+		Branch:    strings.TrimSpace(req.Branch),
+		Replicas:  1,
+		Status:    "idle",
+	}
+	var count int64
+	database.DB.Model(&model.Stage{}).Where("project_id = ? AND name = ?", project.ID, stage.Name).Count(&count)
+	if count > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Stage already exists"})
+	}
+	if err := validateStageName(database.DB, &project, stage.Name); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if stage.Branch == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "branch is required"})
+	}
+	if err := k8s.ValidateBranch(stage.Branch); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Replicas != nil {
+		if err := applyStageReplicas(&stage, *req.Replicas); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	// Catch typos in the branch now rather than when the build clones
+	if project.GitAuth == "github" {
+		token, err := githubToken(userID)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+		}
+		_, err = resolveGithubBranch(c.Request().Context(), token, project.RepoURL, stage.Branch)
+		switch {
+		case err == nil:
+		case errors.Is(err, errGithubUnauthorized):
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		case errors.Is(err, errGithubUnavailable):
+			c.Logger().Warnf("Could not verify %s: %v", project.RepoURL, err)
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if err := checkStageQuota(&project, &stage); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&stage).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create stage"})
+	}
+	if err := saveStageEnv(tx, &stage, req.EnvVars); err != nil {
+		tx.Rollback()
+		fmt.Printf("Error saving stage envs: %v\n", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save env vars"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	if k8s.Client != nil {
+		if _, err := enqueueStageBuild(&project, &stage); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to queue build: " + err.Error()})
+		}
+		stage.Status = "building"
+	}
+	return c.JSON(http.StatusCreated, stage)
+}
+
+// UpdateProjectStage changes a stage's branch (used by the next build), scale or env overrides
+func UpdateProjectStage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	stageID := c.Param("stageId")
+
+	var req model.StageRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", stageID, project.ID).First(&stage).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Stage not found"})
+	}
+
+	shouldRedeploy := false
+	if branch := strings.TrimSpace(req.Branch); branch != "" && branch != stage.Branch {
+		if err := k8s.ValidateBranch(branch); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if project.GitAuth == "github" {
+			token, err := githubToken(userID)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+			}
+			_, err = resolveGithubBranch(c.Request().Context(), token, project.RepoURL, branch)
+			switch {
+			case err == nil:
+			case errors.Is(err, errGithubUnauthorized):
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			case errors.Is(err, errGithubUnavailable):
+				c.Logger().Warnf("Could not verify %s: %v", project.RepoURL, err)
+			default:
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+		}
+		stage.Branch = branch
+	}
+	if req.Replicas != nil && *req.Replicas != stage.Replicas {
+		if err := applyStageReplicas(&stage, *req.Replicas); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err := checkStageQuota(&project, &stage); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+		shouldRedeploy = true
+	}
+
+	tx := database.DB.Begin()
+	if err := tx.Save(&stage).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update stage"})
+	}
+	if req.EnvVars != nil {
+		if err := saveStageEnv(tx, &stage, req.EnvVars); err != nil {
+			tx.Rollback()
+			fmt.Printf("Error saving stage envs: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save env vars"})
+		}
+		shouldRedeploy = true
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	// Stages without a release yet pick the changes up with their first deploy
+	if shouldRedeploy && stage.Image != "" && k8s.Client != nil {
+		if err := deployStage(&project, &stage); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, stage)
+}
+
+// DeleteProjectStage tears a stage down together with its custom domains
+func DeleteProjectStage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	stageID := c.Param("stageId")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", stageID, project.ID).First(&stage).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Stage not found"})
+	}
+
+	var activeBuilds []model.Build
+	database.DB.Where("stage_id = ? AND status IN ?", stage.ID, []string{"queued", "running"}).Find(&activeBuilds)
+	for i := range activeBuilds {
+		cancelBuild(&project, &activeBuilds[i])
+	}
+
+	if err := k8s.DeleteStage(&project, &stage); err != nil {
+		fmt.Printf("[Stage] %v\n", err)
+	}
+
+	var domains []model.Domain
+	database.DB.Where("stage_id = ?", stage.ID).Find(&domains)
+	for _, d := range domains {
+		if err := k8s.DeleteDomainSecret(&project, d.ID); err != nil {
+			fmt.Printf("Failed to delete TLS secret for %s: %v\n", d.Host, err)
+		}
+	}
+	database.DB.Where("stage_id = ?", stage.ID).Delete(&model.Domain{})
+	database.DB.Where("stage_id = ?", stage.ID).Delete(&model.StageEnv{})
+
+	if err := database.DB.Delete(&stage).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete stage"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Stage deleted"})
+}
+
+// DeployProjectStage queues a build of the stage's branch
+func DeployProjectStage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	stageID := c.Param("stageId")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", stageID, project.ID).First(&stage).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Stage not found"})
+	}
+	if k8s.Client == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Kubernetes not connected"})
+	}
+
+	build, err := enqueueStageBuild(&project, &stage)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to queue build: " + err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, build)
+}
+
+// PromoteProjectStage deploys a release of the stage to production. The release's image
+// is deployed as is; nothing is rebuilt.
+func PromoteProjectStage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	stageID := c.Param("stageId")
+
+	var req model.PromoteRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var stage model.Stage
+	if err := database.DB.Where("id = ? AND project_id = ?", stageID, project.ID).First(&stage).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Stage not found"})
+	}
+
+	var source model.Release
+	query := database.DB.Where("project_id = ? AND stage_id = ?", project.ID, stage.ID)
+	if req.ReleaseID != "" {
+		query = query.Where("id = ?", req.ReleaseID)
+	}
+	if err := query.Order("created_at DESC").First(&source).Error; err != nil {
+		if req.ReleaseID != "" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Release not found"})
+		}
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Stage has no release to promote yet"})
+	}
+
+	// Production pulls with the project's current registry credentials
+	if !strings.HasPrefix(source.Image, strings.TrimSuffix(k8s.ImageName(&project), ":latest")+":") {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Release was pushed to a different registry; redeploy the stage first"})
+	}
+	if k8s.Client == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Kubernetes not connected"})
+	}
+	if project.Status == "stopped" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Start the project first"})
+	}
+
+	release := model.Release{
+		ProjectID:      project.ID,
+		BuildID:        source.BuildID,
+		PromotedFromID: &source.ID,
+		Image:          source.Image,
+		Branch:         source.Branch,
+	}
+	tx := database.DB.Begin()
+	if err := tx.Create(&release).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record release"})
+	}
+	if err := tx.Model(&project).Update("release_image", source.Image).Error; err != nil {
+		tx.Rollback()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update project"})
+	}
+	if err := tx.Commit().Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Commit failed"})
+	}
+
+	database.DB.Model(&project).Update("status", "deploying")
+	if err := redeployProject(&project); err != nil {
+		database.DB.Model(&project).Update("status", "error")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to deploy: " + err.Error()})
+	}
+	database.DB.Model(&project).Update("status", "running")
+	fmt.Printf("[Stage] Promoted %s of %s to production\n", stage.Name, project.Name)
+
+	return c.JSON(http.StatusOK, release)
+}
+
+// GetProjectReleases lists the project's most recent releases. ?stage= filters by stage ID,
+// or "production" for production releases only.
+func GetProjectReleases(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	query := database.DB.Where("project_id = ?", project.ID)
+	switch stage := c.QueryParam("stage"); stage {
+	case "":
+	case "production":
+		query = query.Where("stage_id IS NULL")
+	default:
+		if !uuidLikePattern.MatchString(stage) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "stage must be a stage ID or production"})
+		}
+		query = query.Where("stage_id = ?", stage)
+	}
+
+	releases := []model.Release{}
+	if err := query.Order("created_at DESC").Limit(50).Find(&releases).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch releases"})
+	}
+	return c.JSON(http.StatusOK, releases)
+}
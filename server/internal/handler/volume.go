@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"fmt"
+	"foundry-server/internal/database"
+	"foundry-server/internal/k8s"
+	"foundry-server/internal/model"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// maxVolumeNameLength keeps the claim name (project ID + name) readable
+const maxVolumeNameLength = 32
+
+// parseVolumeSize validates a requested volume size
+func parseVolumeSize(size string) (resource.Quantity, error) {
+	q, err := resource.ParseQuantity(strings.TrimSpace(size))
+	if err != nil || q.Sign() <= 0 {
+		return q, fmt.Errorf("size must be a positive quantity like \"1Gi\"")
+	}
+	return q, nil
+}
+
+// validateMountPath checks an absolute, clean mount path that doesn't overlap the project's other volumes
+func validateMountPath(mountPath string, others []model.Volume) error {
+	if !path.IsAbs(mountPath) || path.Clean(mountPath) != mountPath || mountPath == "/" {
+		return fmt.Errorf("mountPath must be an absolute path like /data")
+	}
+	for _, v := range others {
+		if v.MountPath == mountPath ||
+			strings.HasPrefix(mountPath, v.MountPath+"/") || strings.HasPrefix(v.MountPath, mountPath+"/") {
+			return fmt.Errorf("mountPath overlaps volume %s at %s", v.Name, v.MountPath)
+		}
+	}
+	return nil
+}
+
+// volumeScalingError explains why the project can't mount volumes with its current scaling:
+// a volume is attached to one node at a time, so only a single pod can run, replaced in place
+func volumeScalingError(project *model.Project) error {
+	if k8s.PeakReplicas(project) > 1 {
+		return fmt.Errorf("projects with volumes run a single replica")
+	}
+	if project.DeployStrategy == "bluegreen" || project.DeployStrategy == "canary" {
+		return fmt.Errorf("projects with volumes are deployed in place; %s needs a second copy running", project.DeployStrategy)
+	}
+	return nil
+}
+
+// checkVolumeScaling rejects scaling or strategy changes a project with volumes can't run
+func checkVolumeScaling(project *model.Project) error {
+	if !k8s.HasVolumes(project.ID) {
+		return nil
+	}
+	return volumeScalingError(project)
+}
+
+// GetProjectVolumes lists the project's persistent volumes
+func GetProjectVolumes(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	volumes := []model.Volume{}
+	if err := database.DB.Where("project_id = ?", project.ID).Order("name").Find(&volumes).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch volumes"})
+	}
+	return c.JSON(http.StatusOK, volumes)
+}
+
+// CreateProjectVolume adds a persistent volume and redeploys the project with it mounted
+func CreateProjectVolume(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+
+	var req model.VolumeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	volume := model.Volume{
+		ProjectID: project.ID,
+		Name:      strings.TrimSpace(req.Name),
+		MountPath: strings.TrimSpace(req.MountPath),
+	}
+	if len(volume.Name) > maxVolumeNameLength || !stageNamePattern.MatchString(volume.Name) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("volume name must start with a letter, contain only lowercase letters, digits and '-', and be at most %d characters", maxVolumeNameLength)})
+	}
+	var existing []model.Volume
+	database.DB.Where("project_id = ?", project.ID).Find(&existing)
+	for _, v := range existing {
+		if v.Name == volume.Name {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Volume already exists"})
+		}
+	}
+	size, err := parseVolumeSize(req.Size)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	volume.Size = size.String()
+	if err := validateMountPath(volume.MountPath, existing); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := volumeScalingError(&project); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := checkStorageQuota(&project, size, ""); err != nil {
+		return quotaErrorResponse(c, err)
+	}
+
+	if err := database.DB.Create(&volume).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create volume"})
+	}
+
+	if k8s.Client != nil {
+		if err := redeployProject(&project); err != nil {
+			fmt.Printf("Redeploy error: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+	}
+	return c.JSON(http.StatusCreated, volume)
+}
+
+// UpdateProjectVolume grows a volume or moves its mount path. Volumes can't shrink,
+// and are only removed together with the project.
+func UpdateProjectVolume(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	projectID := c.Param("id")
+	name := c.Param("name")
+
+	var req model.VolumeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var project model.Project
+	if err := database.DB.Where("id = ? AND owner_id = ?", projectID, userID).First(&project).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Project not found or access denied"})
+	}
+
+	var volume model.Volume
+	if err := database.DB.Where("project_id = ? AND name = ?", project.ID, name).First(&volume).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Volume not found"})
+	}
+
+	if strings.TrimSpace(req.Size) != "" {
+		size, err := parseVolumeSize(req.Size)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		current := resource.MustParse(volume.Size)
+		if size.Cmp(current) < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("volumes can't shrink below %s", volume.Size)})
+		}
+		if err := checkStorageQuota(&project, size, volume.ID); err != nil {
+			return quotaErrorResponse(c, err)
+		}
+		volume.Size = size.String()
+	}
+	if mountPath := strings.TrimSpace(req.MountPath); mountPath != "" && mountPath != volume.MountPath {
+		var others []model.Volume
+		database.DB.Where("project_id = ? AND id <> ?", project.ID, volume.ID).Find(&others)
+		if err := validateMountPath(mountPath, others); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		volume.MountPath = mountPath
+	}
+
+	if err := database.DB.Save(&volume).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update volume"})
+	}
+
+	if k8s.Client != nil {
+		if err := redeployProject(&project); err != nil {
+			fmt.Printf("Redeploy error: %v\n", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to redeploy: " + err.Error()})
+		}
+	}
+	return c.JSON(http.StatusOK, volume)
+}
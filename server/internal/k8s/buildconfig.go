@@ -0,0 +1,101 @@
+package k8s
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	buildTargetPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	buildArgPattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ValidateBuildConfig checks the project's Dockerfile location, target and build args.
+// Paths must stay inside the repository.
+func ValidateBuildConfig(project *model.Project) error {
+	for name, p := range map[string]string{"dockerfile": project.Dockerfile, "contextSubPath": project.ContextSubPath} {
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+			return fmt.Errorf("%s must be a relative path", name)
+		}
+		if clean := path.Clean(p); clean == ".." || strings.HasPrefix(clean, "../") {
+			return fmt.Errorf("%s must not leave the repository", name)
+		}
+	}
+	if project.BuildTarget != "" && !buildTargetPattern.MatchString(project.BuildTarget) {
+		return fmt.Errorf("target must be a valid build stage name")
+	}
+	if project.BuildTimeout < 0 || project.BuildTimeout > config.App.MaxBuildTimeout {
+		return fmt.Errorf("timeout must be between 1 and %d minutes, or 0 for the default", config.App.MaxBuildTimeout)
+	}
+	for key := range project.BuildArgs {
+		if !buildArgPattern.MatchString(key) {
+			return fmt.Errorf("invalid build arg name %q", key)
+		}
+	}
+	return nil
+}
+
+// BuildTimeout returns how long a build of the project may run.
+// The admin ceiling also applies to projects configured before it was lowered.
+func BuildTimeout(project *model.Project) time.Duration {
+	minutes := project.BuildTimeout
+	if minutes <= 0 {
+		minutes = config.App.DefaultBuildTimeout
+	}
+	if minutes > config.App.MaxBuildTimeout {
+		minutes = config.App.MaxBuildTimeout
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// buildResources are the requests/limits of the Kaniko container. config.Load validated them.
+func buildResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(config.App.BuildCPURequest),
+			corev1.ResourceMemory: resource.MustParse(config.App.BuildMemoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(config.App.BuildCPULimit),
+			corev1.ResourceMemory: resource.MustParse(config.App.BuildMemoryLimit),
+		},
+	}
+}
+
+// kanikoBuildArgs translates the project's build settings into Kaniko flags
+func kanikoBuildArgs(project *model.Project) []string {
+	var args []string
+	if project.Dockerfile != "" {
+		args = append(args, "--dockerfile="+path.Clean(project.Dockerfile))
+	}
+	if project.ContextSubPath != "" {
+		args = append(args, "--context-sub-path="+path.Clean(project.ContextSubPath))
+	}
+	if project.BuildTarget != "" {
+		args = append(args, "--target="+project.BuildTarget)
+	}
+
+	// Sorted so identical settings produce identical Job specs
+	keys := make([]string, 0, len(project.BuildArgs))
+	for k := range project.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", k, project.BuildArgs[k]))
+	}
+	return args
+}
@@ -3,45 +3,75 @@ package k8s
 import (
 	"context"
 	"fmt"
-	"foundry-server/internal/database"
 	"foundry-server/internal/model"
-	"os"
-	"strings"
-	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// TriggerBuild creates a Kaniko Job to build the project and watches it
-func TriggerBuild(projectID, ownerID, projectName, repoURL, branch, token string, envVars map[string]string, port int) error {
+// buildJobName is the Kaniko Job of a build
+func buildJobName(build *model.Build) string {
+	return fmt.Sprintf("build-%s", build.ID)
+}
+
+// buildDestinations are the tags a build pushes: a preview only its pull request tag, any other
+// build its release tag, plus the project's tag when it deploys to production
+func buildDestinations(project *model.Project, build *model.Build) []string {
+	if build.PreviewNumber > 0 {
+		return []string{previewImageName(project, build.PreviewNumber)}
+	}
+	destinations := []string{ReleaseImageName(project, build)}
+	if build.StageID == nil {
+		destinations = append(destinations, ImageName(project))
+	}
+	return destinations
+}
+
+// StartBuild creates the Kaniko Job for a queued build. It does not wait for the build;
+// the build queue polls BuildJobStatus until the Job finishes.
+// The repository is cloned by an init container; repositories without a Dockerfile
+// get one generated from the buildpack of their detected stack.
+func StartBuild(project *model.Project, build *model.Build, creds GitCredentials) error {
 	if Client == nil {
 		return fmt.Errorf("kubernetes client not initialized")
 	}
+	// Both end up on the clone's command line
+	if err := ValidateRepoURL(project); err != nil {
+		return err
+	}
+	if err := ValidateBranch(build.Branch); err != nil {
+		return err
+	}
+	jobName := buildJobName(build)
+	namespace, err := EnsureUserNamespace(project.OwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to prepare namespace: %v", err)
+	}
+	if err := applyBuildpacks(namespace); err != nil {
+		return err
+	}
 
-	// sanitize project ID for k8s naming
-	jobName := fmt.Sprintf("build-%s", projectID)
-	namespace := "apps" // User requested 'apps' namespace
-	
 	// Registry Config
-	registry := os.Getenv("CONTAINER_REGISTRY")
-	if registry == "" {
-		registry = "foundry-local" // Local registry or fallback
+	registrySecret, err := applyRegistrySecret(namespace, project)
+	if err != nil {
+		return err
 	}
-	imageName := fmt.Sprintf("%s/%s:latest", registry, projectID)
 
-	// Prepare git context with auth
-	// Format: git://token@github.com/user/repo.git#refs/heads/branch
-	authRepoURL := strings.Replace(repoURL, "https://", "", 1)
-	gitContext := fmt.Sprintf("git://oauth2:%s@%s#refs/heads/%s", token, authRepoURL, branch)
+	kanikoArgs := []string{"--context=dir:///workspace"}
+	for _, destination := range buildDestinations(project, build) {
+		kanikoArgs = append(kanikoArgs, "--destination="+destination)
+	}
+	kanikoArgs = append(kanikoArgs, "--cache=true")
 
 	// Kaniko Job Spec
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: jobName,
 			Labels: map[string]string{
-				"foundry-app": projectID,
+				"foundry-app": project.ID,
+				"build-id":    build.ID,
 				"type":        "build",
 			},
 		},
@@ -57,7 +87,7 @@ func TriggerBuild(projectID, ownerID, projectName, repoURL, branch, token string
 							Name: "docker-config",
 							VolumeSource: corev1.VolumeSource{
 								Secret: &corev1.SecretVolumeSource{
-									SecretName: "regcred",
+									SecretName: registrySecret,
 									Items: []corev1.KeyToPath{
 										{
 											Key:  ".dockerconfigjson",
@@ -67,21 +97,46 @@ func TriggerBuild(projectID, ownerID, projectName, repoURL, branch, token string
 								},
 							},
 						},
+						{
+							Name:         "workspace",
+							VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+						},
+						{
+							Name: "buildpacks",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{Name: buildpacksConfigMap},
+								},
+							},
+						},
+						{
+							// Created right after the Job; the pod waits for it before starting
+							Name: "git-credentials",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: gitCredentialsSecretName(build.ID),
+									// ssh refuses private keys readable by others
+									DefaultMode: func(i int32) *int32 { return &i }(0400),
+								},
+							},
+						},
+					},
+					InitContainers: []corev1.Container{
+						cloneContainer(project, build.Branch),
+						detectContainer(project),
 					},
 					Containers: []corev1.Container{
 						{
-							Name:  "kaniko",
-							Image: "gcr.io/kaniko-project/executor:latest",
-							Args: []string{
-								"--context=" + gitContext,
-								"--destination=" + imageName,
-								"--cache=true",
-							},
+							Name:      "kaniko",
+							Image:     "gcr.io/kaniko-project/executor:latest",
+							Args:      append(kanikoArgs, kanikoBuildArgs(project)...),
+							Resources: buildResources(),
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "docker-config",
 									MountPath: "/kaniko/.docker",
 								},
+								{Name: "workspace", MountPath: "/workspace"},
 							},
 							Env: []corev1.EnvVar{
 								// Add any necessary env vars for credentials if pushing to external registry
@@ -90,80 +145,74 @@ func TriggerBuild(projectID, ownerID, projectName, repoURL, branch, token string
 					},
 				},
 			},
+			// Kubernetes kills the build pod once the project's timeout has passed
+			ActiveDeadlineSeconds:   func(i int64) *int64 { return &i }(int64(BuildTimeout(project).Seconds())),
+			BackoffLimit:            func(i int32) *int32 { return &i }(0),
 			TTLSecondsAfterFinished: func(i int32) *int32 { return &i }(3600), // Clean up after 1 hour
 		},
 	}
 
-	// Delete existing job if any (simple cleanup)
-	background := metav1.DeletePropagationBackground
-	_ = Client.BatchV1().Jobs(namespace).Delete(context.TODO(), jobName, metav1.DeleteOptions{
-		PropagationPolicy: &background,
-	})
-
-	// Create new Job
-	_, err := Client.BatchV1().Jobs(namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	// Create Job
+	created, err := Client.BatchV1().Jobs(namespace).Create(context.TODO(), job, metav1.CreateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to create build job: %w", err)
 	}
 
-	fmt.Printf("[K8s] Triggered build job: %s for repo: %s\n", jobName, repoURL)
-
-	// Start Watcher Goroutine
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-
-		timeout := time.After(20 * time.Minute) // 20 min timeout
-
-		for {
-			select {
-			case <-timeout:
-				fmt.Printf("[K8s] Build timed out for %s\n", jobName)
-				updateProjectStatus(projectID, "error", "")
-				return
-			case <-ticker.C:
-				j, err := Client.BatchV1().Jobs(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
-				if err != nil {
-					fmt.Printf("[K8s] Error getting job %s: %v\n", jobName, err)
-					continue
-				}
-
-				if j.Status.Succeeded > 0 {
-					fmt.Printf("[K8s] Build succeeded for %s. Deploying...\n", jobName)
-					
-					// Update status
-					updateProjectStatus(projectID, "deploying", "")
-
-					// Trigger Deployment
-					deployURL, err := DeployProject(projectID, ownerID, projectName, envVars, port)
-					
-					if err != nil {
-						fmt.Printf("[K8s] Deploy failed for %s: %v\n", projectName, err)
-						updateProjectStatus(projectID, "error", "")
-					} else {
-						updateProjectStatus(projectID, "running", deployURL)
-					}
-					return
-				}
-
-				if j.Status.Failed > 0 {
-					fmt.Printf("[K8s] Build failed for %s\n", jobName)
-					updateProjectStatus(projectID, "error", "")
-					return
-				}
-			}
-		}
-	}()
+	// Clone credentials, owned by the Job so they are removed with it
+	if err := createGitCredentials(namespace, created, build.ID, project.RepoURL, creds); err != nil {
+		deleteBuildJob(namespace, jobName)
+		return err
+	}
 
+	fmt.Printf("[K8s] Triggered build job: %s for repo: %s\n", jobName, project.RepoURL)
 	return nil
 }
 
-func updateProjectStatus(projectID, status, deployURL string) {
-	if database.DB != nil {
-		updates := map[string]interface{}{"status": status}
-		if deployURL != "" {
-			updates["deploy_url"] = deployURL
+// BuildJobStatus reports whether the build's Job is "running", "succeeded" or "failed",
+// together with the stack detected for it
+func BuildJobStatus(project *model.Project, build *model.Build) (string, string, error) {
+	if Client == nil {
+		return "", "", fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	jobName := buildJobName(build)
+
+	j, err := Client.BatchV1().Jobs(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "failed", "", nil // Deleted out from under us
 		}
-		database.DB.Model(&model.Project{}).Where("id = ?", projectID).Updates(updates)
+		return "", "", err
+	}
+
+	status := "running"
+	if j.Status.Succeeded > 0 {
+		status = "succeeded"
+	} else if j.Status.Failed > 0 {
+		status = "failed"
+	}
+	if status != "running" {
+		deleteGitCredentials(namespace, build.ID)
+	}
+	return status, detectedStack(namespace, jobName), nil
+}
+
+// CancelBuild stops a running build by deleting its Job (and with it the pod and credentials)
+func CancelBuild(project *model.Project, build *model.Build) {
+	if Client == nil {
+		return
+	}
+	namespace := UserNamespace(project.OwnerID)
+	deleteBuildJob(namespace, buildJobName(build))
+	deleteGitCredentials(namespace, build.ID)
+}
+
+func deleteBuildJob(namespace, jobName string) {
+	background := metav1.DeletePropagationBackground
+	err := Client.BatchV1().Jobs(namespace).Delete(context.TODO(), jobName, metav1.DeleteOptions{
+		PropagationPolicy: &background,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		fmt.Printf("[K8s] Failed to delete build job %s: %v\n", jobName, err)
 	}
 }
@@ -0,0 +1,95 @@
+package k8s
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"strconv"
+
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// buildpacks holds the detection script and the Dockerfile templates used for
+// repositories without a Dockerfile
+//
+//go:embed buildpacks/*
+var buildpacks embed.FS
+
+const buildpacksConfigMap = "foundry-buildpacks"
+
+// applyBuildpacks creates or updates the ConfigMap with the detection script and templates
+func applyBuildpacks(namespace string) error {
+	entries, err := buildpacks.ReadDir("buildpacks")
+	if err != nil {
+		return err
+	}
+	data := map[string]string{}
+	for _, e := range entries {
+		content, err := buildpacks.ReadFile(path.Join("buildpacks", e.Name()))
+		if err != nil {
+			return err
+		}
+		data[e.Name()] = string(content)
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   buildpacksConfigMap,
+			Labels: map[string]string{"managed-by": "foundry"},
+		},
+		Data: data,
+	}
+	configMaps := Client.CoreV1().ConfigMaps(namespace)
+	existing, err := configMaps.Get(context.TODO(), cm.Name, metav1.GetOptions{})
+	if err == nil {
+		cm.ResourceVersion = existing.ResourceVersion
+		_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	} else {
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply buildpacks: %v", err)
+	}
+	return nil
+}
+
+// detectContainer runs the detection script against the cloned repository in /workspace,
+// generating a Dockerfile into the build context when the repository has none
+func detectContainer(project *model.Project) corev1.Container {
+	return corev1.Container{
+		Name:    "detect",
+		Image:   "busybox:1.36",
+		Command: []string{"sh", "/buildpacks/detect.sh"},
+		Env: []corev1.EnvVar{
+			{Name: "CONTEXT_SUB_PATH", Value: path.Clean("/" + project.ContextSubPath)[1:]},
+			{Name: "DOCKERFILE", Value: project.Dockerfile},
+			{Name: "PORT", Value: strconv.Itoa(project.Port)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "workspace", MountPath: "/workspace"},
+			{Name: "buildpacks", MountPath: "/buildpacks"},
+		},
+	}
+}
+
+// detectedStack reads the stack reported by the detect container of the build's pod
+func detectedStack(namespace, jobName string) string {
+	pods, err := Client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+	})
+	if err != nil {
+		return ""
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name == "detect" && status.State.Terminated != nil {
+				return status.State.Terminated.Message
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+#!/bin/sh
+# Inspects the checked out repository and, if it has no Dockerfile, generates one
+# from the template of the detected stack. The stack is reported through the
+# container's termination message so Foundry can record it on the build.
+set -e
+
+cd "/workspace/${CONTEXT_SUB_PATH}"
+DOCKERFILE="${DOCKERFILE:-Dockerfile}"
+
+report() {
+  printf '%s' "$1" > /dev/termination-log
+}
+
+if [ -f "$DOCKERFILE" ]; then
+  echo "Using $DOCKERFILE from the repository"
+  report dockerfile
+  exit 0
+fi
+
+if [ -f package.json ]; then
+  stack=node
+elif [ -f go.mod ]; then
+  stack=go
+elif [ -f requirements.txt ]; then
+  stack=python
+elif [ -f index.html ]; then
+  stack=static
+else
+  echo "No $DOCKERFILE found and no supported stack detected (package.json, go.mod, requirements.txt, index.html)" >&2
+  report none
+  exit 1
+fi
+
+mkdir -p "$(dirname "$DOCKERFILE")"
+sed "s/__PORT__/${PORT:-80}/g" "/buildpacks/${stack}.Dockerfile" > "$DOCKERFILE"
+echo "Detected $stack app, generated $DOCKERFILE:"
+cat "$DOCKERFILE"
+report "$stack"
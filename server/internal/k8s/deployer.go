@@ -2,14 +2,19 @@ package k8s
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
+	"sort"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -21,23 +26,39 @@ import (
 // For now, let's just scaffolding it.
 // DeployProject creates Deployment, Service, and Ingress with Secret-based EnvVars
 // Updated: Uses CreateProjectSecret and EnvFrom for security.
-func DeployProject(projectID, ownerID, name string, envVars map[string]string, targetPort int) (string, error) {
+func DeployProject(project *model.Project, envVars map[string]string) (string, error) {
+	return deployProject(project, envVars, false)
+}
+
+// deployProject applies the project's resources. With keepSelector the Service keeps selecting
+// whatever it selects now, so blue/green traffic stays on the new version while the stable
+// Deployment is moved to it.
+func deployProject(project *model.Project, envVars map[string]string, keepSelector bool) (string, error) {
 	if Client == nil {
 		return "", fmt.Errorf("kubernetes client not initialized")
 	}
+	projectID := project.ID
+	ownerID := project.OwnerID
+	name := project.Name
+	targetPort := project.Port
+	resourceName := ResourceName(project)
 
-	namespace := "apps"
-	registry := os.Getenv("CONTAINER_REGISTRY")
-	if registry == "" {
-		registry = "foundry-local"
+	resources, err := resourceRequirements(project)
+	if err != nil {
+		return "", err
 	}
-	imageName := fmt.Sprintf("%s/%s:latest", registry, projectID)
-	
-	labels := map[string]string{
-		"app":         "foundry-app",
-		"project-id":  projectID,
-		"owner-id":    ownerID,
+
+	namespace, err := EnsureUserNamespace(ownerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare namespace: %v", err)
+	}
+	imageName := DeployImage(project)
+	registrySecret, err := applyRegistrySecret(namespace, project)
+	if err != nil {
+		return "", err
 	}
+	
+	labels := projectLabels(project)
 
 	// 1. Create/Update Secret
 	secretName, err := CreateProjectSecret(namespace, projectID, ownerID, envVars)
@@ -45,24 +66,40 @@ func DeployProject(projectID, ownerID, name string, envVars map[string]string, t
 		return "", fmt.Errorf("failed to create secret: %v", err)
 	}
 
+	// Persistent volumes (kept across deploys; only deleted with the project)
+	volumes := projectVolumes(projectID)
+	if err := applyVolumeClaims(namespace, project, volumes); err != nil {
+		return "", err
+	}
+	podVolumes, mounts := volumeMounts(projectID, volumes)
+
 	// 2. Deployment
+	readinessProbe, livenessProbe := containerProbes(project)
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: projectID,
+			Name:   resourceName,
+			Labels: labels,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: func(i int32) *int32 { return &i }(1),
+			Replicas: func(i int32) *int32 { return &i }(desiredReplicas(namespace, project)),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
 			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+					// Changing the checksum changes the pod template, which forces a rolling
+					// restart even when only the Secret contents changed.
+					Annotations: map[string]string{
+						"foundry.io/config-checksum": configChecksum(envVars),
+					},
+				},
 				Spec: corev1.PodSpec{
 					NodeSelector: map[string]string{
 						"role": "apps",
 					},
 					ImagePullSecrets: []corev1.LocalObjectReference{
-						{Name: "regcred"},
+						{Name: registrySecret},
 					},
 					Containers: []corev1.Container{
 						{
@@ -80,27 +117,27 @@ func DeployProject(projectID, ownerID, name string, envVars map[string]string, t
 								},
 							},
 							ImagePullPolicy: corev1.PullAlways,
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("1"),
-									corev1.ResourceMemory: resource.MustParse("1Gi"),
-								},
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("1"),
-									corev1.ResourceMemory: resource.MustParse("1Gi"),
-								},
-							},
+							Resources: resources,
+							ReadinessProbe: readinessProbe,
+							LivenessProbe:  livenessProbe,
+							VolumeMounts:   mounts,
 						},
 					},
+					Volumes: podVolumes,
 				},
 			},
 		},
 	}
+	// A volume is attached to one node at a time, so the old pod has to go before the new one starts
+	if len(volumes) > 0 {
+		deployment.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	}
 
 	// 3. Service
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: projectID,
+			Name:   resourceName,
+			Labels: labels,
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: labels,
@@ -116,40 +153,26 @@ func DeployProject(projectID, ownerID, name string, envVars map[string]string, t
 	}
 
 	// 4. Ingress
-	ingressHost := fmt.Sprintf("%s-foundry.heejunp.com", projectID)
-	pathType := netv1.PathTypePrefix
-	ingressClassName := "nginx"
+	ingressHost := config.App.AppHost(resourceName)
+	ingressClassName := config.App.IngressClass
+
+	// Verified custom domains are served alongside the default host, each with its own certificate
+	domainRules, domainTLS := customDomainRules(projectID, "", service.Name)
 
 	ingress := &netv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: projectID,
+			Name:   resourceName,
+			Labels: labels,
 			Annotations: map[string]string{
-				"cert-manager.io/cluster-issuer": "letsencrypt-prod",
+				"cert-manager.io/cluster-issuer": config.App.ClusterIssuer,
 			},
 		},
 		Spec: netv1.IngressSpec{
 			IngressClassName: &ingressClassName,
-			Rules: []netv1.IngressRule{
-				{
-					Host: ingressHost,
-					IngressRuleValue: netv1.IngressRuleValue{
-						HTTP: &netv1.HTTPIngressRuleValue{
-							Paths: []netv1.HTTPIngressPath{
-								{
-									Path:     "/",
-									PathType: &pathType,
-									Backend: netv1.IngressBackend{
-										Service: &netv1.IngressServiceBackend{
-											Name: service.Name,
-											Port: netv1.ServiceBackendPort{ Number: 80 },
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
+			Rules:            append([]netv1.IngressRule{ingressRule(ingressHost, service.Name)}, domainRules...),
+			TLS: append([]netv1.IngressTLS{
+				{Hosts: []string{ingressHost}, SecretName: appSecretName(projectID)},
+			}, domainTLS...),
 		},
 	}
 
@@ -161,11 +184,34 @@ func DeployProject(projectID, ownerID, name string, envVars map[string]string, t
 		}
 	}
 	
+	// Apply worker and cron process types with the same release
+	if err := applyProcesses(namespace, project, processRelease{
+		Image:          imageName,
+		ConfigChecksum: configChecksum(envVars),
+		SecretName:     secretName,
+		RegistrySecret: registrySecret,
+	}); err != nil {
+		fmt.Printf("[K8s] %v\n", err)
+	}
+
+	// Apply Autoscaler
+	if err := applyAutoscaler(namespace, project, labels); err != nil {
+		fmt.Printf("[K8s] %v\n", err)
+	}
+
+	// Apply NetworkPolicy (must be in place before the app is reachable)
+	if err := applyProjectNetworkPolicy(namespace, project, labels); err != nil {
+		return "", err
+	}
+
 	// Apply Service
 	existingSvc, err := Client.CoreV1().Services(namespace).Get(context.TODO(), service.Name, metav1.GetOptions{})
 	if err == nil {
 		service.ResourceVersion = existingSvc.ResourceVersion
 		service.Spec.ClusterIP = existingSvc.Spec.ClusterIP
+		if keepSelector {
+			service.Spec.Selector = existingSvc.Spec.Selector
+		}
 		_, err = Client.CoreV1().Services(namespace).Update(context.TODO(), service, metav1.UpdateOptions{})
 	} else {
 		_, err = Client.CoreV1().Services(namespace).Create(context.TODO(), service, metav1.CreateOptions{})
@@ -186,8 +232,37 @@ func DeployProject(projectID, ownerID, name string, envVars map[string]string, t
 		fmt.Printf("[K8s] Ingress apply error: %v\n", err)
 	}
 
+	// Remove resources still running under a previous slug, or in the shared namespace
+	pruneRenamedResources(namespace, projectID, resourceName)
+	migrateFromLegacyNamespace(project)
+
 	fmt.Printf("[K8s] Deployed project %s with Secret %s\n", name, secretName)
-	return fmt.Sprintf("http://%s", ingressHost), nil
+	return AppURL(project), nil
+}
+
+// appSecretName is the TLS Secret cert-manager issues for the app's default host
+func appSecretName(projectID string) string {
+	return fmt.Sprintf("%s-tls", projectID)
+}
+
+// configChecksum returns a stable SHA-256 of the merged env map (group variables
+// plus project overrides) so that any config change alters the pod template.
+func configChecksum(envVars map[string]string) string {
+	keys := make([]string, 0, len(envVars))
+	for k := range envVars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		// NUL separators keep "A=B" + "C" distinct from "A" + "B=C"
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(envVars[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // CreateProjectSecret creates or updates a Kubernetes Secret for the project
@@ -240,7 +315,10 @@ func CreateEnvironmentSecret(envID, ownerID string, envVars map[string]string) e
 	if Client == nil {
 		return fmt.Errorf("kubernetes client not initialized")
 	}
-	namespace := "apps"
+	namespace, err := EnsureUserNamespace(ownerID)
+	if err != nil {
+		return err
+	}
 	secretName := fmt.Sprintf("foundry-env-%s", envID)
 
 	secret := &corev1.Secret{
@@ -258,7 +336,7 @@ func CreateEnvironmentSecret(envID, ownerID string, envVars map[string]string) e
 	}
 
 	// Try to create the secret
-	_, err := Client.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	_, err = Client.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
 	if err != nil {
 		// Secret already exists, fetch it to get ResourceVersion for update
 		existingSecret, getErr := Client.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
@@ -281,27 +359,30 @@ func CreateEnvironmentSecret(envID, ownerID string, envVars map[string]string) e
 }
 
 // DeleteEnvironmentSecret deletes the secret for an environment group
-func DeleteEnvironmentSecret(envID string) error {
+// from the owner's namespace (and the shared namespace for groups created before namespaces)
+func DeleteEnvironmentSecret(envID, ownerID string) error {
 	if Client == nil {
 		return nil
 	}
-	namespace := "apps"
 	secretName := fmt.Sprintf("foundry-env-%s", envID)
 	
-	err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to delete secret %s: %v", secretName, err)
+	for _, namespace := range []string{UserNamespace(ownerID), LegacyNamespace} {
+		err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s: %v", secretName, err)
+		}
 	}
 	fmt.Printf("[K8s] Deleted environment secret: %s\n", secretName)
 	return nil
 }
 
 // ScaleProject scales the deployment to the specified replicas
-func ScaleProject(projectID string, replicas int32) error {
+func ScaleProject(project *model.Project, replicas int32) error {
 	if Client == nil {
 		return fmt.Errorf("kubernetes client not initialized")
 	}
-	namespace := "apps"
+	namespace := projectNamespace(project)
+	projectID := ResourceName(project)
 
 	scale, err := Client.AppsV1().Deployments(namespace).GetScale(context.TODO(), projectID, metav1.GetOptions{})
 	if err != nil {
@@ -314,6 +395,10 @@ func ScaleProject(projectID string, replicas int32) error {
 		return fmt.Errorf("failed to update scale for %s: %v", projectID, err)
 	}
 	
+	for _, e := range scaleProcesses(namespace, project, replicas == 0) {
+		fmt.Printf("[K8s] Process scale error: %s\n", e)
+	}
+
 	action := "started"
 	if replicas == 0 {
 		action = "stopped"
@@ -322,30 +407,45 @@ func ScaleProject(projectID string, replicas int32) error {
 	return nil
 }
 
+// DeployedStatus reports what the project's Deployment is doing: "running", "stopped"
+// (scaled to zero) or "" when the project was never deployed
+func DeployedStatus(project *model.Project) string {
+	if Client == nil {
+		return ""
+	}
+	deployment, err := Client.AppsV1().Deployments(projectNamespace(project)).Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		return "stopped"
+	}
+	return "running"
+}
+
 // DeleteProject deletes Deployment, Service, Ingress, and Secret
-func DeleteProject(projectID string) error {
+func DeleteProject(project *model.Project) error {
 	if Client == nil {
 		return fmt.Errorf("kubernetes client not initialized")
 	}
-	namespace := "apps"
+	projectID := project.ID
+	namespace := UserNamespace(project.OwnerID)
 	background := metav1.DeletePropagationBackground
 	opts := metav1.DeleteOptions{PropagationPolicy: &background}
 	var errs []string
 
-	// 1. Delete Ingress
-	if err := Client.NetworkingV1().Ingresses(namespace).Delete(context.TODO(), projectID, opts); err != nil {
-		errs = append(errs, fmt.Sprintf("ingress: %v", err))
-	}
-	// 2. Delete Service
-	if err := Client.CoreV1().Services(namespace).Delete(context.TODO(), projectID, opts); err != nil {
-		errs = append(errs, fmt.Sprintf("service: %v", err))
-	}
-	// 3. Delete Deployment
-	if err := Client.AppsV1().Deployments(namespace).Delete(context.TODO(), projectID, opts); err != nil {
-		errs = append(errs, fmt.Sprintf("deployment: %v", err))
+	// 1. Delete Ingress, Service, Deployment and Autoscaler (by label, plus legacy ID-named ones)
+	errs = append(errs, deleteProjectResources(namespace, projectID, fmt.Sprintf("project-id=%s", projectID), "")...)
+
+	// CronJobs of cron process types (worker Deployments went with the above)
+	errs = append(errs, deleteProcessResources(namespace, fmt.Sprintf("project-id=%s,foundry-process", projectID), nil, nil)...)
+
+	// 2. Delete TLS Secret (issued by cert-manager, so it carries no project label)
+	if err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), appSecretName(projectID), opts); err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, fmt.Sprintf("tls secret: %v", err))
 	}
 
-	// 4. Delete Secret
+	// 3. Delete Secret
 	// We need to construct the secret name logic again or list secrets by label.
 	// Since we delete by ProjectID, let's use LabelSelector to find the secret.
 	secrets, err := Client.CoreV1().Secrets(namespace).List(context.TODO(), metav1.ListOptions{
@@ -361,6 +461,9 @@ func DeleteProject(projectID string) error {
 		errs = append(errs, fmt.Sprintf("list secrets: %v", err))
 	}
 
+	// 4. Delete anything left in the shared namespace by projects never redeployed
+	errs = append(errs, cleanupLegacyNamespace(projectID)...)
+
 	if len(errs) > 0 {
 		return fmt.Errorf("cleanup errors: %s", fmt.Sprint(errs))
 	}
@@ -370,15 +473,17 @@ func DeleteProject(projectID string) error {
 }
 
 // GetPodLogs returns the logs of the first pod for a given project
-func GetPodLogs(projectID string) (string, error) {
+func GetPodLogs(project *model.Project) (string, error) {
 	if Client == nil {
 		return "", fmt.Errorf("kubernetes client not initialized")
 	}
-	namespace := "apps"
+	projectID := project.ID
+	namespace := projectNamespace(project)
 
 	// 1. Get Pods for the project
+	// Previews and stages share the project-id label; only production pods run as foundry-app
 	pods, err := Client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("project-id=%s", projectID),
+		LabelSelector: fmt.Sprintf("app=foundry-app,project-id=%s", projectID),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to list pods: %v", err)
@@ -403,14 +508,16 @@ func GetPodLogs(projectID string) (string, error) {
 }
 
 // GetProjectStats returns CPU and Memory usage for the project
-func GetProjectStats(projectID string) (map[string]string, error) {
+func GetProjectStats(project *model.Project) (map[string]string, error) {
 	if Client == nil {
 		return nil, fmt.Errorf("kubernetes client not initialized")
 	}
+	projectID := project.ID
+	namespace := projectNamespace(project)
 
 	// 1. Get Pod Name
-	pods, err := Client.CoreV1().Pods("apps").List(context.TODO(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("project-id=%s", projectID),
+	pods, err := Client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=foundry-app,project-id=%s", projectID),
 	})
 	if err != nil || len(pods.Items) == 0 {
 		return map[string]string{"cpu": "0", "memory": "0"}, nil // No pods running
@@ -418,8 +525,8 @@ func GetProjectStats(projectID string) (map[string]string, error) {
 	podName := pods.Items[0].Name
 
 	// 2. Call Metrics API (Raw)
-	// format: /apis/metrics.k8s.io/v1beta1/namespaces/apps/pods/<podName>
-	path := fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/namespaces/apps/pods/%s", podName)
+	// format: /apis/metrics.k8s.io/v1beta1/namespaces/<namespace>/pods/<podName>
+	path := fmt.Sprintf("/apis/metrics.k8s.io/v1beta1/namespaces/%s/pods/%s", namespace, podName)
 	
 	data, err := Client.RESTClient().Get().AbsPath(path).DoRaw(context.TODO())
 	if err != nil {
@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DomainSecretName is the TLS Secret (and cert-manager Certificate) name for a custom domain
+func DomainSecretName(domainID string) string {
+	return fmt.Sprintf("domain-%s-tls", domainID)
+}
+
+// DeleteDomainSecret removes the TLS Secret issued for a custom domain.
+// cert-manager does not delete it when the Ingress rule goes away.
+func DeleteDomainSecret(project *model.Project, domainID string) error {
+	if Client == nil {
+		return nil
+	}
+	for _, namespace := range []string{UserNamespace(project.OwnerID), LegacyNamespace} {
+		err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), DomainSecretName(domainID), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete secret %s: %v", DomainSecretName(domainID), err)
+		}
+	}
+	return nil
+}
+
+// ingressRule routes every path on host to the project's Service
+func ingressRule(host, serviceName string) netv1.IngressRule {
+	pathType := netv1.PathTypePrefix
+	return netv1.IngressRule{
+		Host: host,
+		IngressRuleValue: netv1.IngressRuleValue{
+			HTTP: &netv1.HTTPIngressRuleValue{
+				Paths: []netv1.HTTPIngressPath{
+					{
+						Path:     "/",
+						PathType: &pathType,
+						Backend: netv1.IngressBackend{
+							Service: &netv1.IngressServiceBackend{
+								Name: serviceName,
+								Port: netv1.ServiceBackendPort{Number: 80},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// customDomainRules returns extra Ingress rules and TLS sections for the verified domains
+// of the project's stage, or of production when stageID is empty
+func customDomainRules(projectID, stageID, serviceName string) ([]netv1.IngressRule, []netv1.IngressTLS) {
+	if database.DB == nil {
+		return nil, nil
+	}
+
+	query := database.DB.Where("project_id = ? AND verified = ?", projectID, true)
+	if stageID == "" {
+		query = query.Where("stage_id IS NULL")
+	} else {
+		query = query.Where("stage_id = ?", stageID)
+	}
+	var domains []model.Domain
+	query.Order("created_at").Find(&domains)
+
+	var rules []netv1.IngressRule
+	var tls []netv1.IngressTLS
+	for _, d := range domains {
+		rules = append(rules, ingressRule(d.Host, serviceName))
+		tls = append(tls, netv1.IngressTLS{
+			Hosts:      []string{d.Host},
+			SecretName: DomainSecretName(d.ID),
+		})
+	}
+	return rules, tls
+}
+
+// GetCertificateStatus reads the cert-manager Certificate for a TLS Secret
+// and reports "issued", "pending", "failed" or "unknown"
+func GetCertificateStatus(project *model.Project, secretName string) string {
+	if Client == nil {
+		return "unknown"
+	}
+
+	// cert-manager's ingress-shim names the Certificate after the TLS secret
+	path := fmt.Sprintf("/apis/cert-manager.io/v1/namespaces/%s/certificates/%s", projectNamespace(project), secretName)
+	data, err := Client.RESTClient().Get().AbsPath(path).DoRaw(context.TODO())
+	if err != nil {
+		// Not created yet (ingress not applied) or cert-manager not installed
+		return "pending"
+	}
+
+	// Minimal struct for parsing
+	type Certificate struct {
+		Status struct {
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+				Reason string `json:"reason"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+
+	var cert Certificate
+	if err := json.Unmarshal(data, &cert); err != nil {
+		return "unknown"
+	}
+
+	for _, c := range cert.Status.Conditions {
+		if c.Type != "Ready" {
+			continue
+		}
+		if c.Status == "True" {
+			return "issued"
+		}
+		if c.Reason == "Failed" {
+			return "failed"
+		}
+	}
+	return "pending"
+}
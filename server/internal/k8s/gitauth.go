@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"foundry-server/internal/model"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GitCredentials authenticate the clone of a build. Token is used for HTTPS URLs,
+// PrivateKey for SSH URLs; both empty clones anonymously.
+type GitCredentials struct {
+	Username   string
+	Token      string
+	PrivateKey string
+	KnownHosts string
+}
+
+var (
+	// scpLikeURLPattern matches git's short SSH syntax, e.g. git@gitlab.com:org/app.git.
+	// User and host start alphanumeric, so neither git nor ssh reads them as an option.
+	scpLikeURLPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*@[A-Za-z0-9][A-Za-z0-9.-]*:[^/][^\s]*$`)
+	// branchPattern follows git check-ref-format, and doesn't let a branch start with '-'
+	branchPattern = regexp.MustCompile(`^[A-Za-z0-9_][^\s~^:?*\[\\]*$`)
+)
+
+// ValidateRepoURL checks that the repository URL fits the project's clone method
+func ValidateRepoURL(project *model.Project) error {
+	repoURL := project.RepoURL
+	if project.GitAuth == "ssh" {
+		if scpLikeURLPattern.MatchString(repoURL) {
+			return nil
+		}
+		if u, err := url.Parse(repoURL); err == nil && u.Scheme == "ssh" && startsAlphanumeric(u.Hostname()) {
+			return nil
+		}
+		return fmt.Errorf("ssh auth needs an ssh repository URL (git@host:org/repo.git or ssh://...)")
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || !startsAlphanumeric(u.Hostname()) || (u.Scheme != "https" && u.Scheme != "http") || u.User != nil {
+		return fmt.Errorf("repository URL must be an https:// URL without credentials")
+	}
+	// Only anonymous clones may go over plain http; anything else would send a token in the clear
+	if u.Scheme != "https" && project.GitAuth != "none" {
+		return fmt.Errorf("repository URL must be an https:// URL when cloning with a token")
+	}
+	if (project.GitAuth == "" || project.GitAuth == "github") && !strings.EqualFold(u.Hostname(), "github.com") {
+		// The GitHub login must not be sent to other hosts
+		return fmt.Errorf("repositories outside github.com need token, ssh or none auth")
+	}
+	return nil
+}
+
+// ValidateBranch checks a branch name before it ends up on git's command line
+func ValidateBranch(branch string) error {
+	if len(branch) > 255 || !branchPattern.MatchString(branch) ||
+		strings.Contains(branch, "..") || strings.HasSuffix(branch, "/") || strings.HasSuffix(branch, ".lock") {
+		return fmt.Errorf("invalid branch name %q", branch)
+	}
+	return nil
+}
+
+func startsAlphanumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func gitCredentialsSecretName(buildID string) string {
+	return fmt.Sprintf("build-%s-git", buildID)
+}
+
+// createGitCredentials stores the clone secret in a short-lived Secret owned by the build Job,
+// so it never appears in the Job spec and is garbage collected with the Job at the latest
+func createGitCredentials(namespace string, job *batchv1.Job, buildID, repoURL string, creds GitCredentials) error {
+	data := map[string]string{"credentials": ""}
+	if creds.PrivateKey != "" {
+		data["ssh-privatekey"] = creds.PrivateKey
+		data["known_hosts"] = creds.KnownHosts
+	} else if creds.Token != "" {
+		u, err := url.Parse(repoURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid repository URL %q", repoURL)
+		}
+		username := creds.Username
+		if username == "" {
+			username = "oauth2"
+		}
+		credentials := url.URL{Scheme: u.Scheme, User: url.UserPassword(username, creds.Token), Host: u.Host}
+		data["credentials"] = credentials.String() + "\n"
+	}
+
+	controller := true
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: gitCredentialsSecretName(buildID),
+			Labels: map[string]string{
+				"build-id": buildID,
+				"type":     "build",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       job.Name,
+					UID:        job.UID,
+					Controller: &controller,
+				},
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+	if _, err := Client.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create git credentials: %v", err)
+	}
+	return nil
+}
+
+// deleteGitCredentials removes the build's clone secret once the build is over
+func deleteGitCredentials(namespace, buildID string) {
+	err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), gitCredentialsSecretName(buildID), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		fmt.Printf("[K8s] Failed to delete git credentials for build %s: %v\n", buildID, err)
+	}
+}
+
+// cloneContainer checks out the branch into /workspace. HTTPS clones read the token
+// through git's credential store, SSH clones use the deploy key; both come from the
+// mounted per-build Secret.
+func cloneContainer(project *model.Project, branch string) corev1.Container {
+	container := corev1.Container{
+		Name:  "clone",
+		Image: "alpine/git:latest",
+		Args: []string{
+			"-c", "credential.helper=store --file=/git-credentials/credentials",
+			"clone", "--depth=1", "--branch=" + branch, "--", project.RepoURL, "/workspace",
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "workspace", MountPath: "/workspace"},
+			{Name: "git-credentials", MountPath: "/git-credentials", ReadOnly: true},
+		},
+	}
+
+	// The git server must present one of the project's known host keys
+	if project.GitAuth == "ssh" {
+		container.Env = []corev1.EnvVar{{
+			Name: "GIT_SSH_COMMAND",
+			Value: "ssh -i /git-credentials/ssh-privatekey -o IdentitiesOnly=yes" +
+				" -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/git-credentials/known_hosts",
+		}}
+	}
+	return container
+}
@@ -0,0 +1,293 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+	"foundry-server/internal/quota"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LegacyNamespace is the shared namespace apps were deployed to before per-user namespaces.
+// It also hosts the Foundry backend and the registry credentials copied into user namespaces.
+const LegacyNamespace = "apps"
+
+// UserNamespace returns the namespace holding all workloads of a user
+func UserNamespace(ownerID string) string {
+	return config.App.NamespacePrefix + ownerID
+}
+
+// projectNamespace returns where the project's workloads currently run.
+// Projects that haven't been redeployed since namespaces were introduced are still in LegacyNamespace.
+func projectNamespace(project *model.Project) string {
+	namespace := UserNamespace(project.OwnerID)
+	name := ResourceName(project)
+	if _, err := Client.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{}); errors.IsNotFound(err) {
+		if _, err := Client.AppsV1().Deployments(LegacyNamespace).Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+			return LegacyNamespace
+		}
+	}
+	return namespace
+}
+
+// EnsureUserNamespace creates (or updates) the user's namespace together with its
+// ResourceQuota, LimitRange, default-deny NetworkPolicy and registry credentials
+func EnsureUserNamespace(ownerID string) (string, error) {
+	if Client == nil {
+		return "", fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(ownerID)
+	ctx := context.TODO()
+	labels := map[string]string{
+		"managed-by": "foundry",
+		"owner-id":   ownerID,
+	}
+
+	// 1. Namespace
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: labels}}
+	if _, err := Client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("failed to create namespace %s: %v", namespace, err)
+	}
+
+	// 2. ResourceQuota
+	// The API enforces the real per-user quota. The namespace gets twice as much so rolling
+	// updates (surge pods) and build jobs fit, while still capping what a user can reserve.
+	// Only requests are capped: presets set limits at several times their requests, which the
+	// API doesn't count, and the LimitRange below bounds the limits of each container.
+	limits := quota.For(ownerID)
+	cpu, memory := quota.Resources(limits)
+	storage := quota.Storage(limits) // volumes don't surge, so this one is not doubled
+	hardCPU := resource.NewMilliQuantity(cpu.MilliValue()*2, resource.DecimalSI)
+	hardMemory := resource.NewQuantity(memory.Value()*2, resource.BinarySI)
+	resourceQuota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "foundry-quota", Labels: labels},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:     *hardCPU,
+				corev1.ResourceRequestsMemory:  *hardMemory,
+				corev1.ResourceRequestsStorage: storage,
+			},
+		},
+	}
+	if err := applyResourceQuota(namespace, resourceQuota); err != nil {
+		return "", err
+	}
+
+	// 3. LimitRange (defaults for containers that don't set resources, e.g. build jobs)
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "foundry-limits", Labels: labels},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type: corev1.LimitTypeContainer,
+					DefaultRequest: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("100m"),
+						corev1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+					Default: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("500m"),
+						corev1.ResourceMemory: resource.MustParse("256Mi"),
+					},
+					Max: corev1.ResourceList{
+						corev1.ResourceCPU:    cpu,
+						corev1.ResourceMemory: memory,
+					},
+				},
+			},
+		},
+	}
+	limitRanges := Client.CoreV1().LimitRanges(namespace)
+	if existing, err := limitRanges.Get(ctx, limitRange.Name, metav1.GetOptions{}); err == nil {
+		limitRange.ResourceVersion = existing.ResourceVersion
+		_, err = limitRanges.Update(ctx, limitRange, metav1.UpdateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to update limit range: %v", err)
+		}
+	} else if _, err := limitRanges.Create(ctx, limitRange, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create limit range: %v", err)
+	}
+
+	// 4. NetworkPolicies: deny all ingress, except from the ingress controller to apps
+	policies := []*netv1.NetworkPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default-deny", Labels: labels},
+			Spec: netv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "allow-ingress-controller", Labels: labels},
+			Spec: netv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "foundry-app"}},
+				PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress},
+				Ingress: []netv1.NetworkPolicyIngressRule{
+					{
+						From: []netv1.NetworkPolicyPeer{
+							{
+								NamespaceSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{"kubernetes.io/metadata.name": config.App.IngressNamespace},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, p := range policies {
+		if err := applyNetworkPolicy(namespace, p); err != nil {
+			return "", err
+		}
+	}
+
+	// 5. Registry credentials for pushing builds and pulling images
+	if err := copyRegistrySecret(namespace); err != nil {
+		return "", err
+	}
+
+	return namespace, nil
+}
+
+// DeleteUserNamespace removes the user's namespace and everything in it
+func DeleteUserNamespace(ownerID string) error {
+	if Client == nil {
+		return nil
+	}
+	err := Client.CoreV1().Namespaces().Delete(context.TODO(), UserNamespace(ownerID), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete namespace %s: %v", UserNamespace(ownerID), err)
+	}
+	return nil
+}
+
+func applyResourceQuota(namespace string, resourceQuota *corev1.ResourceQuota) error {
+	quotas := Client.CoreV1().ResourceQuotas(namespace)
+	existing, err := quotas.Get(context.TODO(), resourceQuota.Name, metav1.GetOptions{})
+	if err == nil {
+		resourceQuota.ResourceVersion = existing.ResourceVersion
+		_, err = quotas.Update(context.TODO(), resourceQuota, metav1.UpdateOptions{})
+	} else {
+		_, err = quotas.Create(context.TODO(), resourceQuota, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply resource quota: %v", err)
+	}
+	return nil
+}
+
+func applyNetworkPolicy(namespace string, policy *netv1.NetworkPolicy) error {
+	policies := Client.NetworkingV1().NetworkPolicies(namespace)
+	existing, err := policies.Get(context.TODO(), policy.Name, metav1.GetOptions{})
+	if err == nil {
+		policy.ResourceVersion = existing.ResourceVersion
+		_, err = policies.Update(context.TODO(), policy, metav1.UpdateOptions{})
+	} else {
+		_, err = policies.Create(context.TODO(), policy, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply network policy %s: %v", policy.Name, err)
+	}
+	return nil
+}
+
+// copyRegistrySecret copies the shared "regcred" Secret from LegacyNamespace into namespace
+func copyRegistrySecret(namespace string) error {
+	source, err := Client.CoreV1().Secrets(LegacyNamespace).Get(context.TODO(), "regcred", metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil // No registry credentials configured
+		}
+		return fmt.Errorf("failed to read registry secret: %v", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "regcred",
+			Labels: map[string]string{"managed-by": "foundry"},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+	secrets := Client.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if err == nil {
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy registry secret: %v", err)
+	}
+	return nil
+}
+
+// migrateFromLegacyNamespace removes what the project left in LegacyNamespace once its
+// Deployment in the owner's namespace is available, so the app keeps serving during the move.
+// The project is then marked as migrated and later deploys skip the cleanup.
+func migrateFromLegacyNamespace(project *model.Project) {
+	if project.NamespaceMigrated || database.DB == nil {
+		return
+	}
+	p := *project
+	go func() {
+		deadline := time.Now().Add(config.App.RolloutTimeout)
+		for {
+			ready, err := StableReady(&p)
+			if err == nil && ready {
+				break
+			}
+			if time.Now().After(deadline) {
+				fmt.Printf("[K8s] %s is not available yet; its legacy resources stay until the next deploy\n", ResourceName(&p))
+				return
+			}
+			time.Sleep(5 * time.Second)
+		}
+
+		errs := cleanupLegacyNamespace(p.ID)
+		for _, e := range errs {
+			fmt.Printf("[K8s] Legacy cleanup error: %s\n", e)
+		}
+		if len(errs) == 0 {
+			database.DB.Model(&model.Project{}).Where("id = ?", p.ID).Update("namespace_migrated", true)
+		}
+	}()
+}
+
+// cleanupLegacyNamespace removes everything a project left in LegacyNamespace
+// once it has been redeployed into its owner's namespace and is available there
+func cleanupLegacyNamespace(projectID string) []string {
+	// Redirect ingresses stay until they expire; DeleteSlugRedirect looks in both namespaces
+	errs := deleteProjectResources(LegacyNamespace, projectID, fmt.Sprintf("project-id=%s,!foundry-redirect", projectID), "")
+
+	secrets := Client.CoreV1().Secrets(LegacyNamespace)
+	names := []string{appSecretName(projectID)}
+	if database.DB != nil {
+		var domains []model.Domain
+		database.DB.Where("project_id = ?", projectID).Find(&domains)
+		for _, d := range domains {
+			names = append(names, DomainSecretName(d.ID))
+		}
+	}
+	if list, err := secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: fmt.Sprintf("project-id=%s", projectID)}); err == nil {
+		for _, s := range list.Items {
+			names = append(names, s.Name)
+		}
+	}
+	for _, name := range names {
+		if err := secrets.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("legacy secret %s: %v", name, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,186 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ResourceName is the name of the project's Deployment, Service, Ingress and HPA,
+// and the subdomain of its default host. Projects created before slugs existed use their ID.
+func ResourceName(project *model.Project) string {
+	if project.Slug != "" {
+		return project.Slug
+	}
+	return project.ID
+}
+
+// AppURL returns the public URL of the project's default host
+func AppURL(project *model.Project) string {
+	return fmt.Sprintf("https://%s", config.App.AppHost(ResourceName(project)))
+}
+
+// deleteProjectResources deletes the project's Deployments, Services, Ingresses, HPAs and NetworkPolicies
+// matching selector, except those named keep. Resources from before labels were added
+// are only known by the project ID, so they are deleted by name as well (unless projectID is empty).
+func deleteProjectResources(namespace, projectID, selector, keep string) []string {
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+	list := metav1.ListOptions{LabelSelector: selector}
+	ctx := context.TODO()
+	var errs []string
+
+	del := func(kind, name string, fn func(string) error) {
+		if name == keep {
+			return
+		}
+		if err := fn(name); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", kind, name, err))
+		}
+	}
+
+	ingresses := Client.NetworkingV1().Ingresses(namespace)
+	services := Client.CoreV1().Services(namespace)
+	deployments := Client.AppsV1().Deployments(namespace)
+	hpas := Client.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+	policies := Client.NetworkingV1().NetworkPolicies(namespace)
+
+	delIngress := func(n string) error { return ingresses.Delete(ctx, n, opts) }
+	delService := func(n string) error { return services.Delete(ctx, n, opts) }
+	delDeployment := func(n string) error { return deployments.Delete(ctx, n, opts) }
+	delHPA := func(n string) error { return hpas.Delete(ctx, n, opts) }
+	delPolicy := func(n string) error { return policies.Delete(ctx, n, opts) }
+
+	// Legacy, unlabeled resources named after the project ID
+	if projectID != "" {
+		del("ingress", projectID, delIngress)
+		del("service", projectID, delService)
+		del("deployment", projectID, delDeployment)
+		del("autoscaler", projectID, delHPA)
+	}
+
+	if items, err := ingresses.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			del("ingress", i.Name, delIngress)
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list ingresses: %v", err))
+	}
+	if items, err := services.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			del("service", i.Name, delService)
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list services: %v", err))
+	}
+	if items, err := deployments.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			del("deployment", i.Name, delDeployment)
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list deployments: %v", err))
+	}
+	if items, err := hpas.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			del("autoscaler", i.Name, delHPA)
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list autoscalers: %v", err))
+	}
+	if items, err := policies.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			del("network policy", i.Name, delPolicy)
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list network policies: %v", err))
+	}
+
+	return errs
+}
+
+// pruneRenamedResources removes resources left behind under a previous name after a rename.
+// Slug redirect Ingresses are kept; they expire on their own. Pull request previews are
+// kept until their pull request closes, stages until they are deleted, and the new
+// version of a rollout until the rollout ends. Process types are pruned by applyProcesses.
+func pruneRenamedResources(namespace, projectID, current string) {
+	selector := fmt.Sprintf("project-id=%s,!foundry-redirect,!foundry-preview,!foundry-stage,!foundry-rollout,!foundry-process", projectID)
+	for _, e := range deleteProjectResources(namespace, projectID, selector, current) {
+		fmt.Printf("[K8s] Prune error: %s\n", e)
+	}
+}
+
+func redirectIngressName(slug string) string {
+	return fmt.Sprintf("redirect-%s", slug)
+}
+
+// ApplySlugRedirect serves the project's previous subdomain as a permanent redirect to the current one
+func ApplySlugRedirect(project *model.Project, oldSlug string) error {
+	if Client == nil {
+		return fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	oldHost := config.App.AppHost(oldSlug)
+	ingressClassName := config.App.IngressClass
+	ingresses := Client.NetworkingV1().Ingresses(namespace)
+
+	ingress := &netv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: redirectIngressName(oldSlug),
+			Labels: map[string]string{
+				"project-id":       project.ID,
+				"owner-id":         project.OwnerID,
+				"foundry-redirect": oldSlug,
+			},
+			Annotations: map[string]string{
+				"cert-manager.io/cluster-issuer":                 config.App.ClusterIssuer,
+				"nginx.ingress.kubernetes.io/permanent-redirect": AppURL(project) + "$request_uri",
+			},
+		},
+		Spec: netv1.IngressSpec{
+			IngressClassName: &ingressClassName,
+			// The backend is never reached because of the redirect annotation
+			Rules: []netv1.IngressRule{ingressRule(oldHost, ResourceName(project))},
+			TLS: []netv1.IngressTLS{
+				{Hosts: []string{oldHost}, SecretName: redirectIngressName(oldSlug) + "-tls"},
+			},
+		},
+	}
+
+	existing, err := ingresses.Get(context.TODO(), ingress.Name, metav1.GetOptions{})
+	if err == nil {
+		ingress.ResourceVersion = existing.ResourceVersion
+		_, err = ingresses.Update(context.TODO(), ingress, metav1.UpdateOptions{})
+	} else {
+		_, err = ingresses.Create(context.TODO(), ingress, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply redirect for %s: %v", oldHost, err)
+	}
+	fmt.Printf("[K8s] Redirecting %s to %s\n", oldHost, AppURL(project))
+	return nil
+}
+
+// DeleteSlugRedirect removes an expired slug redirect and its certificate.
+// Redirects created before per-user namespaces live in LegacyNamespace.
+func DeleteSlugRedirect(ownerID, oldSlug string) error {
+	if Client == nil {
+		return nil
+	}
+	name := redirectIngressName(oldSlug)
+
+	for _, namespace := range []string{UserNamespace(ownerID), LegacyNamespace} {
+		if err := Client.NetworkingV1().Ingresses(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete redirect ingress %s: %v", name, err)
+		}
+		if err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), name+"-tls", metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete redirect secret %s: %v", name, err)
+		}
+	}
+	return nil
+}
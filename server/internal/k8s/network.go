@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// projectPeers returns the projects this project may connect to (egress)
+// and the projects allowed to connect to it (ingress)
+func projectPeers(projectID string) (egress, ingress []string) {
+	if database.DB == nil {
+		return nil, nil
+	}
+	database.DB.Table("project_peers").Where("project_id = ?", projectID).Pluck("peer_id", &egress)
+	database.DB.Table("project_peers").Where("peer_id = ?", projectID).Pluck("project_id", &ingress)
+	return egress, ingress
+}
+
+// projectSelector matches the pods of the given projects
+func projectSelector(projectIDs []string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "project-id", Operator: metav1.LabelSelectorOpIn, Values: projectIDs},
+		},
+	}
+}
+
+// applyProjectNetworkPolicy isolates the project's pods. Ingress is only allowed from the
+// ingress controller, egress only to DNS and the public internet. Projects of the same owner
+// on the project's allowlist are reachable in both directions as configured.
+func applyProjectNetworkPolicy(namespace string, project *model.Project, labels map[string]string) error {
+	dnsPort := intstr.FromInt32(53)
+	udp := corev1.ProtocolUDP
+	tcp := corev1.ProtocolTCP
+
+	ingressRules := []netv1.NetworkPolicyIngressRule{
+		{
+			From: []netv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"kubernetes.io/metadata.name": config.App.IngressNamespace},
+					},
+				},
+			},
+		},
+	}
+	egressRules := []netv1.NetworkPolicyEgressRule{
+		{
+			Ports: []netv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dnsPort},
+				{Protocol: &tcp, Port: &dnsPort},
+			},
+		},
+		{
+			To: []netv1.NetworkPolicyPeer{
+				{IPBlock: &netv1.IPBlock{CIDR: "0.0.0.0/0", Except: config.App.EgressBlockedCIDRs}},
+			},
+		},
+	}
+
+	egressPeers, ingressPeers := projectPeers(project.ID)
+	if len(ingressPeers) > 0 {
+		ingressRules = append(ingressRules, netv1.NetworkPolicyIngressRule{
+			From: []netv1.NetworkPolicyPeer{{PodSelector: projectSelector(ingressPeers)}},
+		})
+	}
+	if len(egressPeers) > 0 {
+		egressRules = append(egressRules, netv1.NetworkPolicyEgressRule{
+			To: []netv1.NetworkPolicyPeer{{PodSelector: projectSelector(egressPeers)}},
+		})
+	}
+
+	policy := &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   ResourceName(project),
+			Labels: labels,
+		},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"project-id": project.ID}},
+			PolicyTypes: []netv1.PolicyType{netv1.PolicyTypeIngress, netv1.PolicyTypeEgress},
+			Ingress:     ingressRules,
+			Egress:      egressRules,
+		},
+	}
+	return applyNetworkPolicy(namespace, policy)
+}
+
+// RefreshNetworkPolicy re-applies the project's NetworkPolicy without redeploying it,
+// e.g. after another project added or removed it from its allowlist
+func RefreshNetworkPolicy(project *model.Project) error {
+	if Client == nil {
+		return nil
+	}
+	namespace := projectNamespace(project)
+	if namespace == LegacyNamespace {
+		// Isolation applies once the project has been redeployed into its owner's namespace
+		return nil
+	}
+	if _, err := Client.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{}); err != nil {
+		return nil // Never deployed
+	}
+	if err := applyProjectNetworkPolicy(namespace, project, projectLabels(project)); err != nil {
+		return fmt.Errorf("failed to refresh network policy for %s: %v", project.ID, err)
+	}
+	return nil
+}
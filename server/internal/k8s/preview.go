@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+)
+
+// PreviewName is the resource name and subdomain of a pull request preview
+func PreviewName(project *model.Project, number int) string {
+	return fmt.Sprintf("pr-%d-%s", number, ResourceName(project))
+}
+
+// PreviewURL returns the public URL of a pull request preview
+func PreviewURL(project *model.Project, number int) string {
+	return fmt.Sprintf("https://%s", config.App.AppHost(PreviewName(project, number)))
+}
+
+// previewImageName tags preview builds by pull request so they never replace the project's image
+func previewImageName(project *model.Project, number int) string {
+	return fmt.Sprintf("%s:pr-%d", strings.TrimSuffix(ImageName(project), ":latest"), number)
+}
+
+func previewSelector(projectID string, number int) string {
+	return fmt.Sprintf("project-id=%s,foundry-preview=%d", projectID, number)
+}
+
+// DeployPreview runs a pull request's image next to the project: one replica with the
+// project's resources, health checks, env vars and network policy, at PreviewURL.
+// The pods are labeled foundry-preview so the project's Service never selects them.
+func DeployPreview(project *model.Project, number int, envVars map[string]string) (string, error) {
+	if Client == nil {
+		return "", fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace, err := EnsureUserNamespace(project.OwnerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare namespace: %v", err)
+	}
+
+	name := PreviewName(project, number)
+	// Custom domains stay on the project
+	w := workload{
+		Name: name,
+		Labels: map[string]string{
+			"app":             "foundry-preview",
+			"project-id":      project.ID,
+			"owner-id":        project.OwnerID,
+			"foundry-preview": strconv.Itoa(number),
+		},
+		Image:    previewImageName(project, number),
+		Replicas: 1,
+	}
+	if err := applyWorkload(namespace, project, w, envVars); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("[K8s] Deployed preview %s\n", name)
+	return PreviewURL(project, number), nil
+}
+
+// DeletePreview tears a pull request preview down, including the certificate issued for its host
+func DeletePreview(project *model.Project, number int) error {
+	if Client == nil {
+		return nil
+	}
+	name := PreviewName(project, number)
+	if err := deleteWorkload(UserNamespace(project.OwnerID), previewSelector(project.ID, number), []string{name + "-tls"}); err != nil {
+		return fmt.Errorf("preview %s: %v", name, err)
+	}
+	fmt.Printf("[K8s] Deleted preview %s\n", name)
+	return nil
+}
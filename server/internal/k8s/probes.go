@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ValidateHealthCheck checks the project's probe settings
+func ValidateHealthCheck(project *model.Project) error {
+	switch project.HealthCheck {
+	case "", "tcp", "none":
+	case "http":
+		if !strings.HasPrefix(project.HealthPath, "/") {
+			return fmt.Errorf("health path must start with /")
+		}
+	default:
+		return fmt.Errorf("unknown health check type %q", project.HealthCheck)
+	}
+
+	for name, v := range map[string]int{
+		"initialDelay":     project.HealthInitialDelay,
+		"period":           project.HealthPeriod,
+		"timeout":          project.HealthTimeout,
+		"failureThreshold": project.HealthFailThreshold,
+	} {
+		if v < 0 || v > 600 {
+			return fmt.Errorf("%s must be between 0 and 600", name)
+		}
+	}
+	return nil
+}
+
+// containerProbes builds the readiness and liveness probes for the app container.
+// Both use the same check; liveness waits longer so slow starts aren't killed.
+func containerProbes(project *model.Project) (readiness, liveness *corev1.Probe) {
+	var handler corev1.ProbeHandler
+	switch project.HealthCheck {
+	case "none":
+		return nil, nil
+	case "http":
+		handler.HTTPGet = &corev1.HTTPGetAction{
+			Path: project.HealthPath,
+			Port: intstr.FromInt(project.Port),
+		}
+	default:
+		handler.TCPSocket = &corev1.TCPSocketAction{
+			Port: intstr.FromInt(project.Port),
+		}
+	}
+
+	orDefault := func(v, def int) int32 {
+		if v <= 0 {
+			return int32(def)
+		}
+		return int32(v)
+	}
+	initialDelay := orDefault(project.HealthInitialDelay, 5)
+	period := orDefault(project.HealthPeriod, 10)
+	timeout := orDefault(project.HealthTimeout, 2)
+	failures := orDefault(project.HealthFailThreshold, 3)
+
+	readiness = &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: initialDelay,
+		PeriodSeconds:       period,
+		TimeoutSeconds:      timeout,
+		FailureThreshold:    failures,
+	}
+	liveness = &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: initialDelay + period*failures,
+		PeriodSeconds:       period,
+		TimeoutSeconds:      timeout,
+		FailureThreshold:    failures,
+	}
+	return readiness, liveness
+}
@@ -0,0 +1,313 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProcessName is the name of the Deployment or CronJob running a process type of the project
+func ProcessName(project *model.Project, process *model.Process) string {
+	return ResourceName(project) + "-" + process.Name
+}
+
+// processLabels don't include app=foundry-app, so the project's Service never selects worker pods
+func processLabels(project *model.Project, process *model.Process) map[string]string {
+	return map[string]string{
+		"app":             "foundry-" + process.Type,
+		"project-id":      project.ID,
+		"owner-id":        project.OwnerID,
+		"foundry-process": process.Name,
+	}
+}
+
+// processRelease is what the project's process types run: the web process's image, config and size
+type processRelease struct {
+	Image          string
+	ConfigChecksum string
+	SecretName     string
+	RegistrySecret string
+	Resources      corev1.ResourceRequirements
+}
+
+// DeployProcesses applies the project's process types with the release its web process runs
+// right now, so they don't move ahead of it during a blue/green or canary rollout.
+// Projects that were never deployed are skipped; their processes start with the first deploy.
+func DeployProcesses(project *model.Project) error {
+	if Client == nil {
+		return fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	web, err := Client.AppsV1().Deployments(namespace).Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s: %v", ResourceName(project), err)
+	}
+	if len(web.Spec.Template.Spec.Containers) == 0 {
+		return nil
+	}
+	registrySecret, err := applyRegistrySecret(namespace, project)
+	if err != nil {
+		return err
+	}
+	return applyProcesses(namespace, project, processRelease{
+		Image:          web.Spec.Template.Spec.Containers[0].Image,
+		ConfigChecksum: web.Spec.Template.Annotations["foundry.io/config-checksum"],
+		SecretName:     fmt.Sprintf("foundry-secret-%s-%s", project.OwnerID, project.ID),
+		RegistrySecret: registrySecret,
+	})
+}
+
+// applyProcesses creates or updates a Deployment per worker and a CronJob per cron of the
+// project, and removes those of process types no longer declared (or left under a previous slug)
+func applyProcesses(namespace string, project *model.Project, release processRelease) error {
+	if database.DB == nil {
+		return nil
+	}
+	var processes []model.Process
+	if err := database.DB.Where("project_id = ?", project.ID).Find(&processes).Error; err != nil {
+		return fmt.Errorf("failed to load processes: %v", err)
+	}
+	resources, err := resourceRequirements(project)
+	if err != nil {
+		return err
+	}
+	release.Resources = resources
+
+	workers, crons := map[string]bool{}, map[string]bool{}
+	var errs []string
+	for i := range processes {
+		p := &processes[i]
+		var err error
+		if p.Type == "cron" {
+			crons[ProcessName(project, p)] = true
+			err = applyCronProcess(namespace, project, p, release)
+		} else {
+			workers[ProcessName(project, p)] = true
+			err = applyWorkerProcess(namespace, project, p, release)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	selector := fmt.Sprintf("project-id=%s,foundry-process", project.ID)
+	errs = append(errs, deleteProcessResources(namespace, selector, workers, crons)...)
+	if len(errs) > 0 {
+		return fmt.Errorf("process errors: %s", fmt.Sprint(errs))
+	}
+	return nil
+}
+
+// processPodSpec runs the process's command with the shell, like a Procfile line
+func processPodSpec(project *model.Project, process *model.Process, release processRelease, restart corev1.RestartPolicy) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: processLabels(project, process),
+			Annotations: map[string]string{
+				"foundry.io/config-checksum": release.ConfigChecksum,
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeSelector:     map[string]string{"role": "apps"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: release.RegistrySecret}},
+			RestartPolicy:    restart,
+			Containers: []corev1.Container{
+				{
+					Name:    process.Name,
+					Image:   release.Image,
+					Command: []string{"/bin/sh", "-c", process.Command},
+					EnvFrom: []corev1.EnvFromSource{
+						{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: release.SecretName}}},
+					},
+					ImagePullPolicy: corev1.PullAlways,
+					Resources:       release.Resources,
+				},
+			},
+		},
+	}
+}
+
+func applyWorkerProcess(namespace string, project *model.Project, process *model.Process, release processRelease) error {
+	replicas := int32(process.Replicas)
+	if project.Status == "stopped" {
+		replicas = 0
+	}
+	labels := processLabels(project, process)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: ProcessName(project, process), Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: processPodSpec(project, process, release, corev1.RestartPolicyAlways),
+		},
+	}
+
+	deployments := Client.AppsV1().Deployments(namespace)
+	existing, err := deployments.Get(context.TODO(), deployment.Name, metav1.GetOptions{})
+	if err == nil {
+		deployment.ResourceVersion = existing.ResourceVersion
+		_, err = deployments.Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	} else {
+		_, err = deployments.Create(context.TODO(), deployment, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply worker %s: %v", deployment.Name, err)
+	}
+	return nil
+}
+
+func applyCronProcess(namespace string, project *model.Project, process *model.Process, release processRelease) error {
+	suspend := process.Replicas == 0 || project.Status == "stopped"
+	backoffLimit := int32(0)
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: ProcessName(project, process), Labels: processLabels(project, process)},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          process.Schedule,
+			Suspend:           &suspend,
+			ConcurrencyPolicy: batchv1.ForbidConcurrent, // a slow run is never doubled up
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: processLabels(project, process)},
+				Spec: batchv1.JobSpec{
+					BackoffLimit: &backoffLimit,
+					Template:     processPodSpec(project, process, release, corev1.RestartPolicyNever),
+				},
+			},
+		},
+	}
+
+	cronJobs := Client.BatchV1().CronJobs(namespace)
+	existing, err := cronJobs.Get(context.TODO(), cronJob.Name, metav1.GetOptions{})
+	if err == nil {
+		cronJob.ResourceVersion = existing.ResourceVersion
+		_, err = cronJobs.Update(context.TODO(), cronJob, metav1.UpdateOptions{})
+	} else {
+		_, err = cronJobs.Create(context.TODO(), cronJob, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply cron %s: %v", cronJob.Name, err)
+	}
+	return nil
+}
+
+// deleteProcessResources removes worker Deployments and CronJobs matching selector,
+// except those named in keepWorkers and keepCrons
+func deleteProcessResources(namespace, selector string, keepWorkers, keepCrons map[string]bool) []string {
+	background := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &background}
+	list := metav1.ListOptions{LabelSelector: selector}
+	ctx := context.TODO()
+	var errs []string
+
+	deployments := Client.AppsV1().Deployments(namespace)
+	if items, err := deployments.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			if keepWorkers[i.Name] {
+				continue
+			}
+			if err := deployments.Delete(ctx, i.Name, opts); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("worker %s: %v", i.Name, err))
+			}
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list workers: %v", err))
+	}
+
+	cronJobs := Client.BatchV1().CronJobs(namespace)
+	if items, err := cronJobs.List(ctx, list); err == nil {
+		for _, i := range items.Items {
+			if keepCrons[i.Name] {
+				continue
+			}
+			if err := cronJobs.Delete(ctx, i.Name, opts); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("cron %s: %v", i.Name, err))
+			}
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list crons: %v", err))
+	}
+	return errs
+}
+
+// scaleProcesses stops or starts the project's process types along with its web process
+func scaleProcesses(namespace string, project *model.Project, stopped bool) []string {
+	if database.DB == nil {
+		return nil
+	}
+	var processes []model.Process
+	database.DB.Where("project_id = ?", project.ID).Find(&processes)
+
+	ctx := context.TODO()
+	var errs []string
+	for i := range processes {
+		p := &processes[i]
+		name := ProcessName(project, p)
+		if p.Type == "cron" {
+			cronJob, err := Client.BatchV1().CronJobs(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				continue // not deployed yet
+			}
+			suspend := stopped || p.Replicas == 0
+			cronJob.Spec.Suspend = &suspend
+			if _, err := Client.BatchV1().CronJobs(namespace).Update(ctx, cronJob, metav1.UpdateOptions{}); err != nil {
+				errs = append(errs, fmt.Sprintf("cron %s: %v", name, err))
+			}
+			continue
+		}
+
+		scale, err := Client.AppsV1().Deployments(namespace).GetScale(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		scale.Spec.Replicas = int32(p.Replicas)
+		if stopped {
+			scale.Spec.Replicas = 0
+		}
+		if _, err := Client.AppsV1().Deployments(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Sprintf("worker %s: %v", name, err))
+		}
+	}
+	return errs
+}
+
+// GetProcessLogs returns the logs of the newest pod of a process type: a running worker,
+// or the last run of a cron
+func GetProcessLogs(project *model.Project, process *model.Process) (string, error) {
+	if Client == nil {
+		return "", fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	pods, err := Client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("project-id=%s,foundry-process=%s", project.ID, process.Name),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods: %v", err)
+	}
+	if len(pods.Items) == 0 {
+		if process.Type == "cron" {
+			return "No runs yet", nil
+		}
+		return "No pods found (worker might be starting or stopped)", nil
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[j].CreationTimestamp.Before(&pods.Items[i].CreationTimestamp)
+	})
+
+	tail := int64(100)
+	logs, err := Client.CoreV1().Pods(namespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{TailLines: &tail}).DoRaw(context.TODO())
+	if err != nil {
+		return "", fmt.Errorf("failed to get logs: %v", err)
+	}
+	return string(logs), nil
+}
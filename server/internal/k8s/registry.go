@@ -0,0 +1,141 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// platformRegistrySecret holds the credentials of the platform registry (CONTAINER_REGISTRY)
+const platformRegistrySecret = "regcred"
+
+// projectRegistry loads the private registry selected for the project, or nil for the platform registry
+func projectRegistry(project *model.Project) *model.Registry {
+	if project.RegistryID == nil || *project.RegistryID == "" || database.DB == nil {
+		return nil
+	}
+	var registry model.Registry
+	if err := database.DB.First(&registry, "id = ?", *project.RegistryID).Error; err != nil {
+		return nil
+	}
+	return &registry
+}
+
+// ImageName returns the image reference builds push to and deployments pull from.
+// Image projects run their own image, pinned to the resolved digest when there is one.
+func ImageName(project *model.Project) string {
+	if project.SourceType == "image" {
+		if project.ImageDigest == "" || strings.Contains(project.Image, "@") {
+			return project.Image
+		}
+		return project.Image + "@" + project.ImageDigest
+	}
+	prefix := os.Getenv("CONTAINER_REGISTRY")
+	if prefix == "" {
+		prefix = "foundry-local" // Local registry or fallback
+	}
+	if registry := projectRegistry(project); registry != nil {
+		prefix = registry.ImagePrefix
+	}
+	return fmt.Sprintf("%s/%s:latest", prefix, project.ID)
+}
+
+// ReleaseImageName is the immutable tag a build of the project or one of its stages is pushed to,
+// so releases can be promoted between stages without rebuilding
+func ReleaseImageName(project *model.Project, build *model.Build) string {
+	return fmt.Sprintf("%s:build-%s", strings.TrimSuffix(ImageName(project), ":latest"), build.ID)
+}
+
+// DeployImage is the image the project's Deployment runs: its production release once one was recorded
+func DeployImage(project *model.Project) string {
+	if project.SourceType != "image" && project.ReleaseImage != "" {
+		return project.ReleaseImage
+	}
+	return ImageName(project)
+}
+
+func projectRegistrySecretName(projectID string) string {
+	return fmt.Sprintf("%s-registry", projectID)
+}
+
+// applyRegistrySecret writes the docker config for the project's registry and returns
+// the Secret name to use for pushing and pulling. Projects on the platform registry use regcred.
+func applyRegistrySecret(namespace string, project *model.Project) (string, error) {
+	registry := projectRegistry(project)
+	if registry == nil {
+		deleteRegistrySecret(namespace, project.ID)
+		return platformRegistrySecret, nil
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(registry.Username + ":" + registry.Password))
+	config := map[string]interface{}{
+		"auths": map[string]interface{}{
+			registry.Server: map[string]string{
+				"username": registry.Username,
+				"password": registry.Password,
+				"auth":     auth,
+			},
+		},
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: projectRegistrySecretName(project.ID),
+			Labels: map[string]string{
+				"project-id":  project.ID,
+				"owner-id":    project.OwnerID,
+				"registry-id": registry.ID,
+			},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: data},
+	}
+	secrets := Client.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if err == nil {
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(context.TODO(), secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to apply registry secret: %v", err)
+	}
+	return secret.Name, nil
+}
+
+func deleteRegistrySecret(namespace, projectID string) {
+	err := Client.CoreV1().Secrets(namespace).Delete(context.TODO(), projectRegistrySecretName(projectID), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		fmt.Printf("[K8s] Failed to delete registry secret for %s: %v\n", projectID, err)
+	}
+}
+
+// RefreshRegistrySecrets rewrites the docker configs of every project using the registry,
+// e.g. after its credentials changed
+func RefreshRegistrySecrets(registryID string) {
+	if Client == nil || database.DB == nil {
+		return
+	}
+	var projects []model.Project
+	database.DB.Where("registry_id = ?", registryID).Find(&projects)
+	for i := range projects {
+		if _, err := applyRegistrySecret(UserNamespace(projects[i].OwnerID), &projects[i]); err != nil {
+			fmt.Printf("[K8s] %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"fmt"
+
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DefaultSize is used when a project doesn't choose a size
+const DefaultSize = "small"
+
+// SizePreset is a named combination of CPU/memory requests and limits
+type SizePreset struct {
+	CPURequest    string `json:"cpuRequest"`
+	CPULimit      string `json:"cpuLimit"`
+	MemoryRequest string `json:"memoryRequest"`
+	MemoryLimit   string `json:"memoryLimit"`
+}
+
+// SizePresets are the sizes users can pick from. Anything else must be "custom".
+var SizePresets = map[string]SizePreset{
+	"nano":   {CPURequest: "50m", CPULimit: "250m", MemoryRequest: "64Mi", MemoryLimit: "128Mi"},
+	"small":  {CPURequest: "100m", CPULimit: "500m", MemoryRequest: "128Mi", MemoryLimit: "256Mi"},
+	"medium": {CPURequest: "250m", CPULimit: "1", MemoryRequest: "256Mi", MemoryLimit: "512Mi"},
+	"large":  {CPURequest: "500m", CPULimit: "2", MemoryRequest: "512Mi", MemoryLimit: "1Gi"},
+}
+
+// ApplySize fills the project's resource fields from its size preset and validates them.
+// For "custom" sizes the explicit values already on the project are kept.
+func ApplySize(project *model.Project) error {
+	if project.Size == "" {
+		project.Size = DefaultSize
+	}
+
+	if project.Size != "custom" {
+		preset, ok := SizePresets[project.Size]
+		if !ok {
+			return fmt.Errorf("unknown size %q", project.Size)
+		}
+		project.CPURequest = preset.CPURequest
+		project.CPULimit = preset.CPULimit
+		project.MemoryRequest = preset.MemoryRequest
+		project.MemoryLimit = preset.MemoryLimit
+	}
+
+	return validateResources(project)
+}
+
+func validateResources(project *model.Project) error {
+	pairs := []struct {
+		name           string
+		request, limit string
+	}{
+		{"cpu", project.CPURequest, project.CPULimit},
+		{"memory", project.MemoryRequest, project.MemoryLimit},
+	}
+	for _, p := range pairs {
+		req, err := resource.ParseQuantity(p.request)
+		if err != nil {
+			return fmt.Errorf("invalid %s request %q", p.name, p.request)
+		}
+		lim, err := resource.ParseQuantity(p.limit)
+		if err != nil {
+			return fmt.Errorf("invalid %s limit %q", p.name, p.limit)
+		}
+		if req.Sign() <= 0 {
+			return fmt.Errorf("%s request must be greater than zero", p.name)
+		}
+		if req.Cmp(lim) > 0 {
+			return fmt.Errorf("%s request %s exceeds limit %s", p.name, p.request, p.limit)
+		}
+	}
+	return nil
+}
+
+// EffectiveResources returns the requests and limits a project actually runs with.
+// Projects created before sizing existed were backfilled with their old fixed size when
+// the database was migrated; invalid values are an error rather than a silent resize.
+func EffectiveResources(project *model.Project) (SizePreset, error) {
+	if err := validateResources(project); err != nil {
+		return SizePreset{}, fmt.Errorf("project %s: %v", project.Name, err)
+	}
+	return SizePreset{
+		CPURequest:    project.CPURequest,
+		CPULimit:      project.CPULimit,
+		MemoryRequest: project.MemoryRequest,
+		MemoryLimit:   project.MemoryLimit,
+	}, nil
+}
+
+// resourceRequirements builds the container resources for the project
+func resourceRequirements(project *model.Project) (corev1.ResourceRequirements, error) {
+	r, err := EffectiveResources(project)
+	if err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(r.CPURequest),
+			corev1.ResourceMemory: resource.MustParse(r.MemoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(r.CPULimit),
+			corev1.ResourceMemory: resource.MustParse(r.MemoryLimit),
+		},
+	}, nil
+}
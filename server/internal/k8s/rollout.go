@@ -0,0 +1,275 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// projectLabels select the pods of the project's stable Deployment
+func projectLabels(project *model.Project) map[string]string {
+	return map[string]string{
+		"app":        "foundry-app",
+		"project-id": project.ID,
+		"owner-id":   project.OwnerID,
+	}
+}
+
+// rolloutLabels mark the new version during a rollout. Its pods don't run as foundry-app,
+// so the project's Service only sends them traffic once SwitchTraffic points it there.
+func rolloutLabels(project *model.Project) map[string]string {
+	return map[string]string{
+		"app":             "foundry-next",
+		"project-id":      project.ID,
+		"owner-id":        project.OwnerID,
+		"foundry-rollout": "next",
+	}
+}
+
+// RolloutSuffixes name a rollout's resources after the project's slug ("<slug>-next",
+// "<slug>-canary"), so no stage, process type or other project may end up with those names
+var RolloutSuffixes = []string{"next", "canary"}
+
+// RolloutName is the Deployment and Service running the new version during a rollout
+func RolloutName(project *model.Project) string {
+	return ResourceName(project) + "-" + RolloutSuffixes[0]
+}
+
+func canaryIngressName(project *model.Project) string {
+	return ResourceName(project) + "-" + RolloutSuffixes[1]
+}
+
+func rolloutSelector(projectID string) string {
+	return fmt.Sprintf("project-id=%s,foundry-rollout", projectID)
+}
+
+// NeedsRollout reports whether deploying would replace the running version of a project
+// with a blue/green or canary strategy, and returns the image running now. A first deploy,
+// a stopped project or a changed port is applied in place: there is no old version that
+// could keep serving next to the new one. Neither is there for a project with volumes,
+// which only one pod can mount.
+func NeedsRollout(project *model.Project, envVars map[string]string) (bool, string) {
+	if project.DeployStrategy != "bluegreen" && project.DeployStrategy != "canary" {
+		return false, ""
+	}
+	if HasVolumes(project.ID) {
+		return false, ""
+	}
+	if Client == nil || project.Status == "stopped" {
+		return false, ""
+	}
+	existing, err := Client.AppsV1().Deployments(UserNamespace(project.OwnerID)).Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if err != nil || existing.Spec.Replicas == nil || *existing.Spec.Replicas == 0 {
+		return false, ""
+	}
+	containers := existing.Spec.Template.Spec.Containers
+	if len(containers) == 0 || len(containers[0].Ports) == 0 || containers[0].Ports[0].ContainerPort != int32(project.Port) {
+		return false, ""
+	}
+	current := containers[0].Image
+	changed := current != DeployImage(project) ||
+		existing.Spec.Template.Annotations["foundry.io/config-checksum"] != configChecksum(envVars)
+	return changed, current
+}
+
+// StartRollout runs the new version next to the current one without sending it traffic.
+// Blue/green starts it at the current scale; canary with the share of replicas matching its weight.
+func StartRollout(project *model.Project, envVars map[string]string) error {
+	if Client == nil {
+		return fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace, err := EnsureUserNamespace(project.OwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to prepare namespace: %v", err)
+	}
+
+	replicas := desiredReplicas(namespace, project)
+	if project.DeployStrategy == "canary" {
+		replicas = (replicas*int32(project.CanaryWeight) + 99) / 100
+		if replicas < 1 {
+			replicas = 1
+		}
+	}
+	w := workload{
+		Name:     RolloutName(project),
+		Labels:   rolloutLabels(project),
+		Image:    DeployImage(project),
+		Replicas: replicas,
+		Internal: true,
+	}
+	if err := applyWorkload(namespace, project, w, envVars); err != nil {
+		return err
+	}
+	fmt.Printf("[K8s] Started %s rollout of %s\n", project.DeployStrategy, ResourceName(project))
+	return nil
+}
+
+// RolloutHealth reports whether the new version is "ready", still "pending" or has "failed",
+// with a reason for the latter two. A pod that restarted or can't start counts as failed.
+func RolloutHealth(project *model.Project) (string, string, error) {
+	if Client == nil {
+		return "", "", fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	deployment, err := Client.AppsV1().Deployments(namespace).Get(context.TODO(), RolloutName(project), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "failed", "the new version's deployment is gone", nil
+		}
+		return "", "", err
+	}
+
+	pods, err := Client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("project-id=%s,foundry-rollout=next", project.ID),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.RestartCount > 0 {
+				reason := "restarted"
+				if t := cs.LastTerminationState.Terminated; t != nil {
+					reason = fmt.Sprintf("restarted (%s, exit code %d)", t.Reason, t.ExitCode)
+				}
+				return "failed", fmt.Sprintf("pod %s %s", pod.Name, reason), nil
+			}
+			if cs.State.Waiting != nil && podStartFailures[cs.State.Waiting.Reason] {
+				return "failed", fmt.Sprintf("pod %s: %s", pod.Name, cs.State.Waiting.Reason), nil
+			}
+		}
+	}
+
+	want := int32(1)
+	if deployment.Spec.Replicas != nil {
+		want = *deployment.Spec.Replicas
+	}
+	if deployment.Status.ReadyReplicas >= want {
+		return "ready", "", nil
+	}
+	return "pending", fmt.Sprintf("%d of %d pods ready", deployment.Status.ReadyReplicas, want), nil
+}
+
+// podStartFailures are waiting reasons a pod doesn't recover from without a new deploy
+var podStartFailures = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// SwitchTraffic sends traffic to the new version: all of it by pointing the project's
+// Service at the new pods (blue/green), or CanaryWeight percent through a canary Ingress (canary)
+func SwitchTraffic(project *model.Project) error {
+	if Client == nil {
+		return fmt.Errorf("kubernetes client not initialized")
+	}
+	namespace := UserNamespace(project.OwnerID)
+	if project.DeployStrategy == "canary" {
+		return applyCanaryIngress(namespace, project)
+	}
+	return setServiceSelector(namespace, project, rolloutLabels(project))
+}
+
+func setServiceSelector(namespace string, project *model.Project, selector map[string]string) error {
+	services := Client.CoreV1().Services(namespace)
+	service, err := services.Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil // renamed; the new Service selects the stable pods already
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service %s: %v", ResourceName(project), err)
+	}
+	service.Spec.Selector = selector
+	if _, err := services.Update(context.TODO(), service, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update service %s: %v", service.Name, err)
+	}
+	return nil
+}
+
+// applyCanaryIngress mirrors the project's hosts to the new version's Service with the
+// nginx canary annotations. The TLS certificates stay with the main Ingress.
+func applyCanaryIngress(namespace string, project *model.Project) error {
+	service := RolloutName(project)
+	domainRules, _ := customDomainRules(project.ID, "", service)
+	ingressClassName := config.App.IngressClass
+	ingress := &netv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: canaryIngressName(project),
+			Labels: map[string]string{
+				"project-id":      project.ID,
+				"owner-id":        project.OwnerID,
+				"foundry-rollout": "canary",
+			},
+			Annotations: map[string]string{
+				"nginx.ingress.kubernetes.io/canary":        "true",
+				"nginx.ingress.kubernetes.io/canary-weight": strconv.Itoa(project.CanaryWeight),
+			},
+		},
+		Spec: netv1.IngressSpec{
+			IngressClassName: &ingressClassName,
+			Rules:            append([]netv1.IngressRule{ingressRule(config.App.AppHost(ResourceName(project)), service)}, domainRules...),
+		},
+	}
+
+	ingresses := Client.NetworkingV1().Ingresses(namespace)
+	existing, err := ingresses.Get(context.TODO(), ingress.Name, metav1.GetOptions{})
+	if err == nil {
+		ingress.ResourceVersion = existing.ResourceVersion
+		_, err = ingresses.Update(context.TODO(), ingress, metav1.UpdateOptions{})
+	} else {
+		_, err = ingresses.Create(context.TODO(), ingress, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply canary ingress: %v", err)
+	}
+	return nil
+}
+
+// PromoteRollout moves the stable Deployment to the new version. Blue/green traffic stays
+// on the new version's pods until FinishRollout; canary traffic keeps its split.
+func PromoteRollout(project *model.Project, envVars map[string]string) error {
+	_, err := deployProject(project, envVars, true)
+	return err
+}
+
+// StableReady reports whether every replica of the stable Deployment runs its current template
+func StableReady(project *model.Project) (bool, error) {
+	if Client == nil {
+		return false, fmt.Errorf("kubernetes client not initialized")
+	}
+	deployment, err := Client.AppsV1().Deployments(UserNamespace(project.OwnerID)).Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	want := int32(1)
+	if deployment.Spec.Replicas != nil {
+		want = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas >= want &&
+		deployment.Status.ReadyReplicas >= want &&
+		deployment.Status.Replicas == deployment.Status.UpdatedReplicas, nil
+}
+
+// FinishRollout points all traffic back at the stable Deployment and removes the new
+// version's Deployment, Service, Secret and canary Ingress. It ends a promoted rollout
+// as well as a rolled back one.
+func FinishRollout(project *model.Project) error {
+	if Client == nil {
+		return nil
+	}
+	namespace := UserNamespace(project.OwnerID)
+	if err := setServiceSelector(namespace, project, projectLabels(project)); err != nil {
+		return err
+	}
+	return deleteWorkload(namespace, rolloutSelector(project.ID), nil)
+}
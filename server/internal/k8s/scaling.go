@@ -0,0 +1,105 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"foundry-server/internal/model"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PeakReplicas returns the highest number of pods a project may run,
+// which is what its resources should be reserved for in quota checks
+func PeakReplicas(project *model.Project) int32 {
+	if project.Autoscale {
+		return int32(project.MaxReplicas)
+	}
+	if project.Replicas < 1 {
+		return 1
+	}
+	return int32(project.Replicas)
+}
+
+// StartReplicas returns the replica count used when a stopped project is started
+func StartReplicas(project *model.Project) int32 {
+	if project.Autoscale {
+		return int32(project.MinReplicas)
+	}
+	return PeakReplicas(project)
+}
+
+// desiredReplicas decides the Deployment replica count for a deploy.
+// With autoscaling enabled the HPA owns the count, so an existing value is preserved.
+func desiredReplicas(namespace string, project *model.Project) int32 {
+	if project.Status == "stopped" {
+		return 0
+	}
+	if !project.Autoscale {
+		return PeakReplicas(project)
+	}
+
+	existing, err := Client.AppsV1().Deployments(namespace).Get(context.TODO(), ResourceName(project), metav1.GetOptions{})
+	if err == nil && existing.Spec.Replicas != nil && *existing.Spec.Replicas > 0 {
+		return *existing.Spec.Replicas
+	}
+	return StartReplicas(project)
+}
+
+// applyAutoscaler creates or updates the project's HorizontalPodAutoscaler,
+// or removes it when autoscaling is disabled
+func applyAutoscaler(namespace string, project *model.Project, labels map[string]string) error {
+	hpas := Client.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+
+	if !project.Autoscale {
+		err := hpas.Delete(context.TODO(), ResourceName(project), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete autoscaler: %v", err)
+		}
+		return nil
+	}
+
+	minReplicas := int32(project.MinReplicas)
+	targetCPU := int32(project.TargetCPU)
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   ResourceName(project),
+			Labels: labels,
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       ResourceName(project),
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: int32(project.MaxReplicas),
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ResourceMetricSourceType,
+					Resource: &autoscalingv2.ResourceMetricSource{
+						Name: "cpu",
+						Target: autoscalingv2.MetricTarget{
+							Type:               autoscalingv2.UtilizationMetricType,
+							AverageUtilization: &targetCPU,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	existing, err := hpas.Get(context.TODO(), hpa.Name, metav1.GetOptions{})
+	if err == nil {
+		hpa.ResourceVersion = existing.ResourceVersion
+		_, err = hpas.Update(context.TODO(), hpa, metav1.UpdateOptions{})
+	} else {
+		_, err = hpas.Create(context.TODO(), hpa, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply autoscaler: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"fmt"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+)
+
+// StageName is the resource name and subdomain of a stage: <slug>-<stage name>
+func StageName(project *model.Project, stage *model.Stage) string {
+	return fmt.Sprintf("%s-%s", ResourceName(project), stage.Name)
+}
+
+// StageURL returns the public URL of a stage's default host
+func StageURL(project *model.Project, stage *model.Stage) string {
+	return fmt.Sprintf("https://%s", config.App.AppHost(StageName(project, stage)))
+}
+
+// Stages are selected by ID so resources left under a previous project slug are found too
+func stageSelector(projectID, stageID string) string {
+	return fmt.Sprintf("project-id=%s,foundry-stage=%s", projectID, stageID)
+}
+
+// DeployStage runs the stage's release next to production with the project's resources,
+// health checks and network policy, at StageURL and the stage's verified custom domains
+func DeployStage(project *model.Project, stage *model.Stage, envVars map[string]string) (string, error) {
+	if Client == nil {
+		return "", fmt.Errorf("kubernetes client not initialized")
+	}
+	if stage.Image == "" {
+		return "", fmt.Errorf("stage %s has no release yet", stage.Name)
+	}
+	namespace, err := EnsureUserNamespace(project.OwnerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare namespace: %v", err)
+	}
+
+	name := StageName(project, stage)
+	domainRules, domainTLS := customDomainRules(project.ID, stage.ID, name)
+	w := workload{
+		Name: name,
+		Labels: map[string]string{
+			"app":           "foundry-stage",
+			"project-id":    project.ID,
+			"owner-id":      project.OwnerID,
+			"foundry-stage": stage.ID,
+		},
+		Image:       stage.Image,
+		Replicas:    int32(stage.Replicas),
+		DomainRules: domainRules,
+		DomainTLS:   domainTLS,
+	}
+	if err := applyWorkload(namespace, project, w, envVars); err != nil {
+		return "", err
+	}
+
+	// Remove the stage's resources still running under a previous project slug
+	for _, e := range deleteProjectResources(namespace, "", stageSelector(project.ID, stage.ID), name) {
+		fmt.Printf("[K8s] Prune error: %s\n", e)
+	}
+
+	fmt.Printf("[K8s] Deployed stage %s\n", name)
+	return StageURL(project, stage), nil
+}
+
+// DeleteStage tears a stage down, including the certificate issued for its default host
+func DeleteStage(project *model.Project, stage *model.Stage) error {
+	if Client == nil {
+		return nil
+	}
+	name := StageName(project, stage)
+	if err := deleteWorkload(UserNamespace(project.OwnerID), stageSelector(project.ID, stage.ID), []string{name + "-tls"}); err != nil {
+		return fmt.Errorf("stage %s: %v", name, err)
+	}
+	fmt.Printf("[K8s] Deleted stage %s\n", name)
+	return nil
+}
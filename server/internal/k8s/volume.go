@@ -0,0 +1,129 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// volumeClaimName is named after the project ID rather than its slug: a claim can't be
+// renamed, and its data has to stay with the project across renames
+func volumeClaimName(projectID, volumeName string) string {
+	return fmt.Sprintf("%s-%s", projectID, volumeName)
+}
+
+// projectVolumes loads the project's volumes
+func projectVolumes(projectID string) []model.Volume {
+	if database.DB == nil {
+		return nil
+	}
+	var volumes []model.Volume
+	database.DB.Where("project_id = ?", projectID).Order("name").Find(&volumes)
+	return volumes
+}
+
+// HasVolumes reports whether the project mounts persistent volumes
+func HasVolumes(projectID string) bool {
+	return len(projectVolumes(projectID)) > 0
+}
+
+// applyVolumeClaims creates the project's PersistentVolumeClaims, or grows them to their
+// requested size. Claims are never deleted here, so redeploys keep their data.
+func applyVolumeClaims(namespace string, project *model.Project, volumes []model.Volume) error {
+	claims := Client.CoreV1().PersistentVolumeClaims(namespace)
+	for _, v := range volumes {
+		size, err := resource.ParseQuantity(v.Size)
+		if err != nil {
+			return fmt.Errorf("invalid size %q of volume %s", v.Size, v.Name)
+		}
+		name := volumeClaimName(project.ID, v.Name)
+
+		existing, err := claims.Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil {
+			current := existing.Spec.Resources.Requests[corev1.ResourceStorage]
+			if size.Cmp(current) <= 0 {
+				continue
+			}
+			// Expansion needs a StorageClass with allowVolumeExpansion
+			existing.Spec.Resources.Requests[corev1.ResourceStorage] = size
+			if _, err := claims.Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to resize volume %s: %v", v.Name, err)
+			}
+			continue
+		}
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get volume %s: %v", v.Name, err)
+		}
+
+		claim := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					"project-id":     project.ID,
+					"owner-id":       project.OwnerID,
+					"foundry-volume": v.Name,
+				},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+			},
+		}
+		if config.App.VolumeStorageClass != "" {
+			storageClass := config.App.VolumeStorageClass
+			claim.Spec.StorageClassName = &storageClass
+		}
+		if _, err := claims.Create(context.TODO(), claim, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create volume %s: %v", v.Name, err)
+		}
+		fmt.Printf("[K8s] Created volume %s (%s) for project %s\n", name, v.Size, project.ID)
+	}
+	return nil
+}
+
+// volumeMounts returns the pod volumes and container mounts of the project's claims
+func volumeMounts(projectID string, volumes []model.Volume) ([]corev1.Volume, []corev1.VolumeMount) {
+	var podVolumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for _, v := range volumes {
+		podVolumes = append(podVolumes, corev1.Volume{
+			Name: v.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: volumeClaimName(projectID, v.Name)},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: v.Name, MountPath: v.MountPath})
+	}
+	return podVolumes, mounts
+}
+
+// DeleteVolumes deletes the project's PersistentVolumeClaims and with them its data.
+// Only call it once the user confirmed deleting the project together with its volumes.
+func DeleteVolumes(project *model.Project) error {
+	if Client == nil {
+		return nil
+	}
+	claims := Client.CoreV1().PersistentVolumeClaims(UserNamespace(project.OwnerID))
+	list, err := claims.List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("project-id=%s,foundry-volume", project.ID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %v", err)
+	}
+	for _, c := range list.Items {
+		if err := claims.Delete(context.TODO(), c.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete volume %s: %v", c.Name, err)
+		}
+	}
+	return nil
+}
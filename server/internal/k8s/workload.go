@@ -0,0 +1,195 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"foundry-server/internal/config"
+	"foundry-server/internal/model"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// workload is a deployment of a project next to its main one (a stage or a pull request preview).
+// It reuses the project's resources, health checks and network policy, and is served at Name's host.
+// Its pods carry an "app" label other than foundry-app so the project's Service never selects them.
+type workload struct {
+	Name     string
+	Labels   map[string]string
+	Image    string
+	Replicas int32
+	Internal bool // no Ingress of its own; traffic is routed to its Service by other means
+
+	// Verified custom domains routed to the workload in addition to its default host
+	DomainRules []netv1.IngressRule
+	DomainTLS   []netv1.IngressTLS
+}
+
+// applyWorkload creates or updates the workload's env Secret, Deployment, Service and Ingress
+func applyWorkload(namespace string, project *model.Project, w workload, envVars map[string]string) error {
+	resources, err := resourceRequirements(project)
+	if err != nil {
+		return err
+	}
+	registrySecret, err := applyRegistrySecret(namespace, project)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+
+	// 1. Env Secret
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("foundry-secret-%s-%s", project.OwnerID, w.Name),
+			Labels: w.Labels,
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: envVars,
+	}
+	secrets := Client.CoreV1().Secrets(namespace)
+	if existing, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{}); err == nil {
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update secret %s: %v", secret.Name, err)
+		}
+	} else if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create secret %s: %v", secret.Name, err)
+	}
+
+	// 2. Deployment
+	readinessProbe, livenessProbe := containerProbes(project)
+	replicas := w.Replicas
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: w.Name, Labels: w.Labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: w.Labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: w.Labels,
+					Annotations: map[string]string{
+						"foundry.io/config-checksum": configChecksum(envVars),
+					},
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:     map[string]string{"role": "apps"},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: registrySecret}},
+					Containers: []corev1.Container{
+						{
+							Name:  "app",
+							Image: w.Image,
+							Ports: []corev1.ContainerPort{{ContainerPort: int32(project.Port)}},
+							EnvFrom: []corev1.EnvFromSource{
+								{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name}}},
+							},
+							ImagePullPolicy: corev1.PullAlways,
+							Resources:       resources,
+							ReadinessProbe:  readinessProbe,
+							LivenessProbe:   livenessProbe,
+						},
+					},
+				},
+			},
+		},
+	}
+	deployments := Client.AppsV1().Deployments(namespace)
+	if existing, err := deployments.Get(ctx, w.Name, metav1.GetOptions{}); err == nil {
+		deployment.ResourceVersion = existing.ResourceVersion
+		_, err = deployments.Update(ctx, deployment, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update deployment %s: %v", w.Name, err)
+		}
+	} else if _, err := deployments.Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create deployment %s: %v", w.Name, err)
+	}
+
+	// 3. Service
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: w.Name, Labels: w.Labels},
+		Spec: corev1.ServiceSpec{
+			Selector: w.Labels,
+			Ports: []corev1.ServicePort{
+				{Protocol: corev1.ProtocolTCP, Port: 80, TargetPort: intstr.FromInt(project.Port)},
+			},
+			Type: corev1.ServiceTypeClusterIP,
+		},
+	}
+	services := Client.CoreV1().Services(namespace)
+	if existing, err := services.Get(ctx, w.Name, metav1.GetOptions{}); err == nil {
+		service.ResourceVersion = existing.ResourceVersion
+		service.Spec.ClusterIP = existing.Spec.ClusterIP
+		_, err = services.Update(ctx, service, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update service %s: %v", w.Name, err)
+		}
+	} else if _, err := services.Create(ctx, service, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create service %s: %v", w.Name, err)
+	}
+
+	if w.Internal {
+		return nil
+	}
+
+	// 4. Ingress
+	host := config.App.AppHost(w.Name)
+	ingressClassName := config.App.IngressClass
+	ingress := &netv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   w.Name,
+			Labels: w.Labels,
+			Annotations: map[string]string{
+				"cert-manager.io/cluster-issuer": config.App.ClusterIssuer,
+			},
+		},
+		Spec: netv1.IngressSpec{
+			IngressClassName: &ingressClassName,
+			Rules:            append([]netv1.IngressRule{ingressRule(host, w.Name)}, w.DomainRules...),
+			TLS:              append([]netv1.IngressTLS{{Hosts: []string{host}, SecretName: w.Name + "-tls"}}, w.DomainTLS...),
+		},
+	}
+	ingresses := Client.NetworkingV1().Ingresses(namespace)
+	if existing, err := ingresses.Get(ctx, w.Name, metav1.GetOptions{}); err == nil {
+		ingress.ResourceVersion = existing.ResourceVersion
+		_, err = ingresses.Update(ctx, ingress, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update ingress %s: %v", w.Name, err)
+		}
+	} else if _, err := ingresses.Create(ctx, ingress, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create ingress %s: %v", w.Name, err)
+	}
+	return nil
+}
+
+// deleteWorkload removes everything matching selector, using the same label-based cleanup
+// as DeleteProject, plus the TLS Secrets cert-manager issued (they carry no labels)
+func deleteWorkload(namespace, selector string, tlsSecrets []string) error {
+	errs := deleteProjectResources(namespace, "", selector, "")
+
+	secrets := Client.CoreV1().Secrets(namespace)
+	if list, err := secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: selector}); err == nil {
+		for _, s := range list.Items {
+			if err := secrets.Delete(context.TODO(), s.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Sprintf("secret %s: %v", s.Name, err))
+			}
+		}
+	} else {
+		errs = append(errs, fmt.Sprintf("list secrets: %v", err))
+	}
+	for _, name := range tlsSecrets {
+		if err := secrets.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("tls secret %s: %v", name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("cleanup errors: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
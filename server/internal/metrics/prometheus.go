@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"foundry-server/internal/config"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// ErrorRate returns the share (0..1) of 5xx responses the ingress controller served for
+// service recently. ok is false when Prometheus isn't configured or the service got no traffic.
+func ErrorRate(ctx context.Context, namespace, service string) (float64, bool, error) {
+	if config.App.PrometheusURL == "" {
+		return 0, false, nil
+	}
+	query := strings.NewReplacer("{namespace}", namespace, "{service}", service).Replace(config.App.ErrorRateQuery)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		config.App.PrometheusURL+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, fmt.Errorf("prometheus unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("prometheus returned %s", resp.Status)
+	}
+
+	// Instant vector: {"data": {"result": [{"value": [<time>, "<value>"]}]}}
+	var body struct {
+		Data struct {
+			Result []struct {
+				Value []interface{} `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, false, fmt.Errorf("invalid prometheus response: %v", err)
+	}
+	if len(body.Data.Result) == 0 || len(body.Data.Result[0].Value) != 2 {
+		return 0, false, nil
+	}
+	raw, _ := body.Data.Result[0].Value[1].(string)
+	rate, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, false, nil // 0/0 without traffic
+	}
+	return rate, true, nil
+}
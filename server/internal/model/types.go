@@ -16,6 +16,7 @@ type User struct {
 	AvatarURL   string    `json:"avatarUrl"`
 	AccessToken string    `json:"-"` // Don't expose this in JSON
 	IsActive    bool      `gorm:"default:false" json:"isActive"`
+	IsAdmin     bool      `gorm:"-" json:"isAdmin"` // From config (ADMIN_USERS)
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 	Projects    []Project `gorm:"foreignKey:OwnerID" json:"projects,omitempty"`
@@ -24,13 +25,82 @@ type User struct {
 type Project struct {
 	ID        string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
 	Name      string `gorm:"not null" json:"name"`
-	RepoURL   string `gorm:"not null" json:"repoUrl"`
+	Slug      string `gorm:"uniqueIndex" json:"slug"` // DNS-safe subdomain and resource name
+	RepoURL   string `gorm:"not null" json:"repoUrl"` // empty for image projects
 	Port      int    `gorm:"default:80" json:"port"`
 	DeployURL string `json:"deployUrl"`
 	Status    string `gorm:"default:'building'" json:"status"` // building, running, error
 	OwnerID   string `gorm:"type:uuid;not null" json:"ownerId"`
 	Owner     User   `gorm:"foreignKey:OwnerID" json:"owner"`
+	// Set once nothing of the project is left in the shared namespace of the first deploys
+	NamespaceMigrated bool `gorm:"default:false" json:"-"`
 	
+	// Resource sizing: a preset (nano, small, medium, large) or "custom" with explicit values.
+	// The resolved requests/limits are always stored so deploys don't depend on preset changes.
+	Size          string `gorm:"default:'small'" json:"size"`
+	CPURequest    string `json:"cpuRequest"`
+	CPULimit      string `json:"cpuLimit"`
+	MemoryRequest string `json:"memoryRequest"`
+	MemoryLimit   string `json:"memoryLimit"`
+
+	// Scaling: a fixed replica count, or an HPA policy when Autoscale is enabled
+	Replicas    int  `gorm:"default:1" json:"replicas"`
+	Autoscale   bool `gorm:"default:false" json:"autoscale"`
+	MinReplicas int  `gorm:"default:1" json:"minReplicas"`
+	MaxReplicas int  `gorm:"default:1" json:"maxReplicas"`
+	TargetCPU   int  `gorm:"default:80" json:"targetCpu"` // average CPU utilization (% of request)
+
+	// Rollout strategy for new versions (image or config changes): "rolling" (default, in place),
+	// "bluegreen" (the new version starts next to the old one and takes all traffic once ready)
+	// or "canary" (CanaryWeight percent of requests go to it first). The new version is watched
+	// for AnalysisMinutes and rolled back when it loses readiness or its 5xx rate exceeds MaxErrorRate.
+	DeployStrategy  string `gorm:"default:'rolling'" json:"deployStrategy"`
+	CanaryWeight    int    `gorm:"default:20" json:"canaryWeight"`   // percent of requests
+	AnalysisMinutes int    `gorm:"default:5" json:"analysisMinutes"` // how long the new version is watched
+	MaxErrorRate    int    `gorm:"default:5" json:"maxErrorRate"`    // percent of 5xx responses
+
+	// Health checks: "tcp" (default, on Port), "http" (GET HealthPath) or "none"
+	HealthCheck         string `gorm:"default:'tcp'" json:"healthCheck"`
+	HealthPath          string `json:"healthPath"`
+	HealthInitialDelay  int    `gorm:"default:5" json:"healthInitialDelay"`  // seconds
+	HealthPeriod        int    `gorm:"default:10" json:"healthPeriod"`       // seconds
+	HealthTimeout       int    `gorm:"default:2" json:"healthTimeout"`       // seconds
+	HealthFailThreshold int    `gorm:"default:3" json:"healthFailThreshold"` // consecutive failures
+
+	// Build: where the Dockerfile is and how Kaniko builds it. Empty values use Kaniko's defaults.
+	Dockerfile     string            `json:"dockerfile"`     // path relative to the build context
+	ContextSubPath string            `json:"contextSubPath"` // repository subdirectory used as build context
+	BuildTarget    string            `json:"buildTarget"`    // multi-stage target
+	BuildArgs      map[string]string `gorm:"serializer:json" json:"buildArgs"`
+	BuildTimeout   int               `json:"buildTimeout"` // minutes, 0 uses the default
+
+	// Clone access: "github" (the owner's GitHub login), "token" (HTTPS token, e.g. GitLab or Gitea),
+	// "ssh" (deploy key generated by Foundry) or "none" (public repository).
+	// The secret halves are kept in GitCredential.
+	GitAuth         string `gorm:"default:'github'" json:"gitAuth"`
+	GitKnownHosts   string `json:"gitKnownHosts"`   // SSH host keys the git server must present
+	DeployPublicKey string `json:"deployPublicKey"` // add to the repository as a read-only deploy key
+
+	// Pull request previews: every open PR against the project's branch gets its own
+	// ephemeral deployment. PreviewHookID is the GitHub webhook registered for it.
+	Previews      bool  `gorm:"default:false" json:"previews"`
+	PreviewHookID int64 `json:"-"`
+
+	// Registry the image is pushed to and pulled from; nil uses the platform registry
+	RegistryID *string `gorm:"type:uuid" json:"registryId"`
+
+	// Source: "git" builds RepoURL with Kaniko, "image" deploys a prebuilt Image as is.
+	// Image projects are pinned to the digest the tag pointed to when deployed;
+	// with AutoRedeploy they follow the tag whenever it moves.
+	SourceType   string `gorm:"default:'git'" json:"sourceType"`
+	Image        string `json:"image"` // e.g. ghcr.io/org/app:1.2
+	ImageDigest  string `json:"imageDigest"`
+	AutoRedeploy bool   `gorm:"default:false" json:"autoRedeploy"`
+
+	// Image of the production release (a build, or a release promoted from a stage).
+	// Empty for projects deployed before releases were recorded; they run ImageName.
+	ReleaseImage string `json:"releaseImage"`
+
 	ViewCount int `gorm:"default:0" json:"viewCount"`
 	LikeCount int `gorm:"default:0" json:"likeCount"`
 	
@@ -41,6 +111,11 @@ type Project struct {
     // M2M Relation for Reusable Envs
     Environments []Environment `gorm:"many2many:project_environments;" json:"environments,omitempty"`
 
+	// Allowlist of projects (same owner) this project may connect to over the cluster network.
+	// Everything else inside the cluster is blocked by the project's NetworkPolicy.
+	Peers   []Project `gorm:"many2many:project_peers;" json:"-"`
+	PeerIDs []string  `gorm:"-" json:"peerIds"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -92,6 +167,279 @@ func (ev *EnvironmentVar) AfterFind(tx *gorm.DB) error {
     return nil
 }
 
+// Domain is a custom hostname attached to a project.
+// It is only added to the Ingress once ownership has been proven via a TXT record.
+type Domain struct {
+	ID                string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID         string     `gorm:"type:uuid;not null;index" json:"projectId"`
+	StageID           *string    `gorm:"type:uuid;index" json:"stageId"` // nil routes to production
+	Host              string     `gorm:"not null;uniqueIndex:idx_domains_verified_host,where:verified = true" json:"host"` // unverified claims don't block the host
+	VerificationToken string     `gorm:"not null" json:"verificationToken"`
+	Verified          bool       `gorm:"default:false" json:"verified"`
+	VerifiedAt        *time.Time `json:"verifiedAt"`
+	CreatedAt         time.Time  `json:"createdAt"`
+
+	// Dynamic fields (not in DB table)
+	CertStatus string `gorm:"-" json:"certStatus"` // pending, issued, failed, unknown
+	TXTName    string `gorm:"-" json:"txtName"`
+	TXTValue   string `gorm:"-" json:"txtValue"`
+}
+
+// Registry holds a user's credentials for a private container registry
+type Registry struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OwnerID     string    `gorm:"type:uuid;not null;index" json:"ownerId"`
+	Name        string    `gorm:"not null" json:"name"`
+	Server      string    `gorm:"not null" json:"server"`      // e.g. ghcr.io, registry.example.com:5000
+	ImagePrefix string    `gorm:"not null" json:"imagePrefix"` // where images are pushed, e.g. ghcr.io/my-org
+	Username    string    `gorm:"not null" json:"username"`
+	Password    string    `gorm:"not null" json:"-"` // Stored encrypted in DB
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// BeforeSave hook - encrypt password before saving to database
+func (r *Registry) BeforeSave(tx *gorm.DB) error {
+	if r.Password == "" {
+		return nil
+	}
+	encrypted, err := crypto.Encrypt(r.Password)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt registry password: %v", err)
+	}
+	r.Password = encrypted
+	return nil
+}
+
+// AfterSave hook - restore the plaintext so the struct stays usable after saving
+func (r *Registry) AfterSave(tx *gorm.DB) error {
+	return r.AfterFind(tx)
+}
+
+// AfterFind hook - decrypt password after loading from database
+func (r *Registry) AfterFind(tx *gorm.DB) error {
+	if r.Password == "" {
+		return nil
+	}
+	decrypted, err := crypto.Decrypt(r.Password)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt registry password: %v", err)
+	}
+	r.Password = decrypted
+	return nil
+}
+
+// GitCredential is the clone secret of a project that doesn't use the owner's GitHub login:
+// an HTTPS token, or the private half of the project's deploy key
+type GitCredential struct {
+	ProjectID  string    `gorm:"primaryKey;type:uuid" json:"projectId"`
+	Username   string    `json:"username"`
+	Token      string    `json:"-"` // Stored encrypted in DB
+	PrivateKey string    `json:"-"` // Stored encrypted in DB
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// BeforeSave hook - encrypt token and key before saving to database
+func (g *GitCredential) BeforeSave(tx *gorm.DB) error {
+	for _, field := range []*string{&g.Token, &g.PrivateKey} {
+		if *field == "" {
+			continue
+		}
+		encrypted, err := crypto.Encrypt(*field)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt git credential: %v", err)
+		}
+		*field = encrypted
+	}
+	return nil
+}
+
+// AfterSave hook - restore the plaintext so the struct stays usable after saving
+func (g *GitCredential) AfterSave(tx *gorm.DB) error {
+	return g.AfterFind(tx)
+}
+
+// AfterFind hook - decrypt token and key after loading from database
+func (g *GitCredential) AfterFind(tx *gorm.DB) error {
+	for _, field := range []*string{&g.Token, &g.PrivateKey} {
+		if *field == "" {
+			continue
+		}
+		decrypted, err := crypto.Decrypt(*field)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt git credential: %v", err)
+		}
+		*field = decrypted
+	}
+	return nil
+}
+
+// Build is a single image build of a project. Builds wait in the queue (status "queued")
+// until both the global and the owner's concurrency limits allow them to run.
+type Build struct {
+	ID            string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID     string     `gorm:"type:uuid;not null;index" json:"projectId"`
+	Branch        string     `json:"branch"`
+	PreviewNumber int        `gorm:"default:0;index" json:"previewNumber,omitempty"` // pull request number; 0 builds the project itself
+	StageID       *string    `gorm:"type:uuid;index" json:"stageId,omitempty"`       // stage the build deploys to; nil is production
+	Status        string     `gorm:"default:'queued';index" json:"status"`           // queued, running, succeeded, failed, cancelled
+	Stack         string     `json:"stack"`                                          // dockerfile, or the detected stack: node, go, python, static
+	StartedAt     *time.Time `json:"startedAt"`
+	FinishedAt    *time.Time `json:"finishedAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+
+	// Dynamic fields (not in DB table)
+	QueuePosition int `gorm:"-" json:"queuePosition,omitempty"` // 1-based, only while queued
+}
+
+// Preview is the ephemeral deployment of an open pull request, served at pr-<number>-<slug>
+type Preview struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string    `gorm:"type:uuid;not null;uniqueIndex:idx_preview_project_number" json:"projectId"`
+	Number    int       `gorm:"not null;uniqueIndex:idx_preview_project_number" json:"number"`
+	Title     string    `json:"title"`
+	Branch    string    `json:"branch"`                          // head branch of the pull request
+	HeadSHA   string    `json:"headSha"`                         // commit the status is reported on
+	Status    string    `gorm:"default:'building'" json:"status"` // building, running, error
+	DeployURL string    `json:"deployUrl"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Stage is a named deployment of a project next to production (e.g. staging tracking develop),
+// served at <slug>-<name>. Production is the project itself. A stage shares the project's
+// port, resources and health checks; it has its own branch, scale, env overrides and domains.
+type Stage struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string    `gorm:"type:uuid;not null;uniqueIndex:idx_stage_project_name" json:"projectId"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_stage_project_name" json:"name"`
+	Branch    string    `gorm:"not null" json:"branch"`
+	Replicas  int       `gorm:"default:1" json:"replicas"`    // 0 keeps the stage stopped
+	Image     string    `json:"image"`                        // image of the current release; empty until first deployed
+	Status    string    `gorm:"default:'idle'" json:"status"` // idle, building, deploying, running, error
+	DeployURL string    `json:"deployUrl"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	EnvVars []StageEnv `gorm:"foreignKey:StageID" json:"envVars,omitempty"`
+}
+
+// StageEnv is a variable of a stage. It overrides the project's variable with the same key.
+type StageEnv struct {
+	ID      uint   `gorm:"primaryKey" json:"id"`
+	StageID string `gorm:"type:uuid;not null;index" json:"stageId"`
+	Key     string `gorm:"not null" json:"key"`
+	Value   string `gorm:"not null" json:"value"` // Stored encrypted in DB
+}
+
+// BeforeSave hook - encrypt value before saving to database
+func (se *StageEnv) BeforeSave(tx *gorm.DB) error {
+	if se.Value == "" {
+		return nil
+	}
+	encrypted, err := crypto.Encrypt(se.Value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt stage env variable: %v", err)
+	}
+	se.Value = encrypted
+	return nil
+}
+
+// AfterFind hook - decrypt value after loading from database
+func (se *StageEnv) AfterFind(tx *gorm.DB) error {
+	if se.Value == "" {
+		return nil
+	}
+	decrypted, err := crypto.Decrypt(se.Value)
+	if err != nil {
+		fmt.Printf("[WARN] Failed to decrypt stage env variable ID %d: %v\n", se.ID, err)
+		return nil
+	}
+	se.Value = decrypted
+	return nil
+}
+
+// Release is an image that went live on production or on a stage, either freshly built
+// or promoted from another stage's release. Images are tagged per build, so promoting
+// never rebuilds.
+type Release struct {
+	ID             string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID      string    `gorm:"type:uuid;not null;index" json:"projectId"`
+	StageID        *string   `gorm:"type:uuid;index" json:"stageId"` // nil is production
+	BuildID        *string   `gorm:"type:uuid" json:"buildId"`
+	PromotedFromID *string   `gorm:"type:uuid" json:"promotedFromId"` // release the image was promoted from
+	Image          string    `gorm:"not null" json:"image"`
+	Branch         string    `json:"branch"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// Rollout is a blue/green or canary deploy of a new production version. It moves from
+// starting (new version coming up) to analyzing (new version taking traffic while watched)
+// to promoting (stable Deployment moved to the new version) and ends as succeeded,
+// rolled_back (the old version kept serving) or failed.
+type Rollout struct {
+	ID            string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID     string     `gorm:"type:uuid;not null;index" json:"projectId"`
+	Strategy      string     `json:"strategy"` // bluegreen, canary
+	Image         string     `json:"image"`
+	PreviousImage string     `json:"previousImage"`
+	Phase         string     `gorm:"default:'starting';index" json:"phase"` // starting, analyzing, promoting, succeeded, rolled_back, failed
+	Reason        string     `json:"reason"`                                // why the rollout was rolled back or failed
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"` // when the current phase started
+	FinishedAt    *time.Time `json:"finishedAt"`
+}
+
+// Process is an additional process type of a project, declared Procfile-style next to the
+// web process. A "worker" runs Replicas pods without a Service or Ingress; a "cron" runs as a
+// CronJob on Schedule. Both use the project's image, resources and env Secret, run Command
+// in place of the image's entrypoint and stop with the project.
+type Process struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string    `gorm:"type:uuid;not null;uniqueIndex:idx_process_project_name" json:"projectId"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_process_project_name" json:"name"`
+	Type      string    `gorm:"not null" json:"type"` // worker, cron
+	Command   string    `gorm:"not null" json:"command"`
+	Schedule  string    `json:"schedule"`                  // cron only, standard 5-field syntax
+	Replicas  int       `gorm:"default:1" json:"replicas"` // workers: pod count; crons: 0 suspends the schedule, 1 runs it
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Volume is a PersistentVolumeClaim mounted into the project's web process at MountPath.
+// Its data survives restarts and redeploys; the claim is only deleted with the project,
+// and only when the deletion is confirmed. A project with volumes runs a single replica
+// that is replaced with the Recreate strategy, since the claim is attached to one node at a time.
+type Volume struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	ProjectID string    `gorm:"type:uuid;not null;uniqueIndex:idx_volume_project_name" json:"projectId"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_volume_project_name" json:"name"`
+	Size      string    `gorm:"not null" json:"size"` // e.g. "1Gi"; can grow, never shrink
+	MountPath string    `gorm:"not null" json:"mountPath"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UserQuota overrides the installation-wide limits for a single user.
+// Zero values keep the default from the configuration.
+type UserQuota struct {
+	UserID      string    `gorm:"primaryKey;type:uuid" json:"userId"`
+	MaxProjects int       `json:"maxProjects"`
+	CPU         string    `json:"cpu"`     // total CPU requests, e.g. "4"
+	Memory      string    `json:"memory"`  // total memory requests, e.g. "4Gi"
+	Storage     string    `json:"storage"` // total persistent volume size, e.g. "20Gi"
+	MaxBuilds   int       `json:"maxBuilds"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SlugRedirect keeps a project's previous slug redirecting to its current host
+// for a grace period after a rename. Reserved slugs can't be taken by other projects.
+type SlugRedirect struct {
+	Slug      string    `gorm:"primaryKey" json:"slug"`
+	ProjectID string    `gorm:"type:uuid;not null;index" json:"projectId"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 type InviteCode struct {
 	ID         string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	Code       string `gorm:"uniqueIndex;not null"`
@@ -138,6 +486,55 @@ func (pe *ProjectEnv) AfterFind(tx *gorm.DB) error {
     return nil
 }
 
+// EnvVersion is an immutable snapshot of a project's or environment group's variables.
+// A new version is recorded every time the variable set changes, including restores.
+type EnvVersion struct {
+    ID         uint            `gorm:"primaryKey" json:"id"`
+    TargetType string          `gorm:"not null;index:idx_env_versions_target;uniqueIndex:idx_env_versions_number" json:"targetType"` // project, environment
+    TargetID   string          `gorm:"type:uuid;not null;index:idx_env_versions_target;uniqueIndex:idx_env_versions_number" json:"targetId"`
+    Version    int             `gorm:"not null;uniqueIndex:idx_env_versions_number" json:"version"` // unique per target
+    ChangedBy  string          `gorm:"type:uuid;not null" json:"changedBy"`
+    Note       string          `json:"note"` // e.g. "restored from v3"
+    Variables  []EnvVersionVar `gorm:"foreignKey:EnvVersionID" json:"variables,omitempty"`
+    CreatedAt  time.Time       `json:"createdAt"`
+}
+
+type EnvVersionVar struct {
+    ID           uint   `gorm:"primaryKey" json:"id"`
+    EnvVersionID uint   `gorm:"not null;index" json:"-"`
+    Key          string `gorm:"not null" json:"key"`
+    Value        string `gorm:"not null" json:"value"` // Stored encrypted in DB
+}
+
+// BeforeSave hook - encrypt value before saving to database
+func (vv *EnvVersionVar) BeforeSave(tx *gorm.DB) error {
+    if vv.Value == "" {
+        return nil
+    }
+
+    encrypted, err := crypto.Encrypt(vv.Value)
+    if err != nil {
+        return fmt.Errorf("failed to encrypt env version variable: %v", err)
+    }
+    vv.Value = encrypted
+    return nil
+}
+
+// AfterFind hook - decrypt value after loading from database
+func (vv *EnvVersionVar) AfterFind(tx *gorm.DB) error {
+    if vv.Value == "" {
+        return nil
+    }
+
+    decrypted, err := crypto.Decrypt(vv.Value)
+    if err != nil {
+        fmt.Printf("[WARN] Failed to decrypt env version variable ID %d: %v\n", vv.ID, err)
+        return nil
+    }
+    vv.Value = decrypted
+    return nil
+}
+
 // DTOs
 
 type CreateProjectRequest struct {
@@ -147,12 +544,147 @@ type CreateProjectRequest struct {
     Branch  string            `json:"branch"`
     EnvVars []EnvVarRequest   `json:"envVars"`
     EnvironmentIDs []string   `json:"environmentIds"`
+	PeerIDs []string          `json:"peerIds"`
+	Health  *HealthCheckRequest `json:"healthCheck"`
+	Strategy *StrategyRequest `json:"strategy"`
+	Build   *BuildRequest     `json:"build"`
+	Git     *GitAuthRequest   `json:"git"` // nil clones with the owner's GitHub login
+	RegistryID string         `json:"registryId"` // empty uses the platform registry
+	SourceType string         `json:"sourceType"` // "git" (default) or "image"
+	Image      string         `json:"image"`
+	AutoRedeploy bool         `json:"autoRedeploy"`
+	ResourceRequest
 }
 
 type UpdateProjectRequest struct {
-	Action  string            `json:"action"` // "start", "stop"
+	Action  string            `json:"action"` // "start", "stop", "redeploy" (image projects: pull the tag again)
 	Port    int               `json:"port"`
+	Slug    string            `json:"slug"`
 	EnvVars []EnvVarRequest   `json:"envVars"`
+	Scaling *ScalingRequest   `json:"scaling"`
+	Health  *HealthCheckRequest `json:"healthCheck"`
+	Strategy *StrategyRequest `json:"strategy"` // applies to the next deploy
+	PeerIDs []string          `json:"peerIds"` // nil keeps the allowlist, [] clears it
+	Build   *BuildRequest     `json:"build"`   // applies to the next build
+	Git     *GitAuthRequest   `json:"git"`     // applies to the next build
+	RepoURL *string           `json:"repoUrl"` // with git only, e.g. to switch from https to ssh
+	Previews *bool            `json:"previews"` // registers or removes the GitHub webhook
+	RegistryID *string        `json:"registryId"` // "" switches back to the platform registry; triggers a rebuild
+	Image      *string        `json:"image"`        // image projects only
+	AutoRedeploy *bool        `json:"autoRedeploy"` // image projects only
+	ResourceRequest
+}
+
+// StageRequest creates or updates a stage. Nil fields are left unchanged on update.
+type StageRequest struct {
+	Name     string          `json:"name"` // create only
+	Branch   string          `json:"branch"`
+	Replicas *int            `json:"replicas"`
+	EnvVars  []EnvVarRequest `json:"envVars"` // nil keeps the overrides, [] clears them
+}
+
+// ProcessRequest declares one process type, like a line of a Procfile
+type ProcessRequest struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // worker, cron
+	Command  string `json:"command"`
+	Schedule string `json:"schedule"`
+	Replicas *int   `json:"replicas"` // defaults to 1
+}
+
+// ProcessUpdateRequest scales or reschedules a single process type. Nil fields are left unchanged.
+type ProcessUpdateRequest struct {
+	Command  *string `json:"command"`
+	Schedule *string `json:"schedule"`
+	Replicas *int    `json:"replicas"`
+}
+
+// VolumeRequest adds a volume, or resizes and moves one on update (Name is create only)
+type VolumeRequest struct {
+	Name      string `json:"name"`
+	Size      string `json:"size"`
+	MountPath string `json:"mountPath"`
+}
+
+// PromoteRequest moves a stage release to production. An empty ReleaseID promotes the stage's latest release.
+type PromoteRequest struct {
+	ReleaseID string `json:"releaseId"`
+}
+
+// GitAuthRequest configures how builds clone the repository
+type GitAuthRequest struct {
+	Auth       string `json:"auth"`       // github (default), token, ssh, none
+	Username   string `json:"username"`   // token auth; defaults to oauth2
+	Token      string `json:"token"`      // token auth; empty keeps the stored token
+	KnownHosts string `json:"knownHosts"` // ssh auth; known_hosts lines of the git server
+}
+
+// RegistryRequest registers private registry credentials
+type RegistryRequest struct {
+	Name        string `json:"name"`
+	Server      string `json:"server"`
+	ImagePrefix string `json:"imagePrefix"` // defaults to <server>/<username>
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+}
+
+// BuildRequest configures the Kaniko build. Empty values use the defaults (./Dockerfile at the repo root).
+type BuildRequest struct {
+	Dockerfile     string            `json:"dockerfile"`
+	ContextSubPath string            `json:"contextSubPath"`
+	Target         string            `json:"target"`
+	BuildArgs      map[string]string `json:"buildArgs"`
+	Timeout        int               `json:"timeout"` // minutes, 0 uses the default
+}
+
+// HealthCheckRequest configures the liveness/readiness probes. Zero timings keep the defaults.
+type HealthCheckRequest struct {
+	Type             string `json:"type"` // tcp, http, none
+	Path             string `json:"path"`
+	InitialDelay     int    `json:"initialDelay"`
+	Period           int    `json:"period"`
+	Timeout          int    `json:"timeout"`
+	FailureThreshold int    `json:"failureThreshold"`
+}
+
+// StrategyRequest selects how new versions are rolled out. Zero values keep the defaults.
+type StrategyRequest struct {
+	Type            string `json:"type"` // rolling, bluegreen, canary
+	CanaryWeight    int    `json:"canaryWeight"`
+	AnalysisMinutes int    `json:"analysisMinutes"`
+	MaxErrorRate    int    `json:"maxErrorRate"`
+}
+
+// ScalingRequest sets a fixed replica count, or an autoscaling policy when Autoscale is true
+type ScalingRequest struct {
+	Replicas    int  `json:"replicas"`
+	Autoscale   bool `json:"autoscale"`
+	MinReplicas int  `json:"minReplicas"`
+	MaxReplicas int  `json:"maxReplicas"`
+	TargetCPU   int  `json:"targetCpu"`
+}
+
+// ResourceRequest selects a size preset, or explicit values when Size is "custom"
+type ResourceRequest struct {
+	Size          string `json:"size"` // nano, small, medium, large, custom
+	CPURequest    string `json:"cpuRequest"`
+	CPULimit      string `json:"cpuLimit"`
+	MemoryRequest string `json:"memoryRequest"`
+	MemoryLimit   string `json:"memoryLimit"`
+}
+
+// QuotaUsage reports a user's current consumption next to their limits
+type QuotaUsage struct {
+	Projects     int    `json:"projects"`
+	MaxProjects  int    `json:"maxProjects"`
+	CPU          string `json:"cpu"`
+	CPUQuota     string `json:"cpuQuota"`
+	Memory       string `json:"memory"`
+	MemoryQuota  string `json:"memoryQuota"`
+	Storage      string `json:"storage"`
+	StorageQuota string `json:"storageQuota"`
+	Builds       int    `json:"builds"`
+	MaxBuilds    int    `json:"maxBuilds"`
 }
 
 type EnvVarRequest struct {
@@ -160,6 +692,14 @@ type EnvVarRequest struct {
     Value string `json:"value"`
 }
 
+// EnvDiffEntry describes how a single key changed between two env versions
+type EnvDiffEntry struct {
+    Key    string `json:"key"`
+    Change string `json:"change"` // added, removed, changed
+    From   string `json:"from,omitempty"`
+    To     string `json:"to,omitempty"`
+}
+
 type ActivateRequest struct {
 	InviteCode string `json:"inviteCode"`
 }
@@ -0,0 +1,69 @@
+package quota
+
+import (
+	"foundry-server/internal/config"
+	"foundry-server/internal/database"
+	"foundry-server/internal/model"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// For returns the effective limits of a user: the admin override where set,
+// the installation defaults otherwise
+func For(userID string) model.UserQuota {
+	limits := model.UserQuota{
+		UserID:      userID,
+		MaxProjects: config.App.UserMaxProjects,
+		CPU:         config.App.UserCPUQuota,
+		Memory:      config.App.UserMemoryQuota,
+		Storage:     config.App.UserStorageQuota,
+		MaxBuilds:   config.App.UserMaxBuilds,
+	}
+	if database.DB == nil {
+		return limits
+	}
+
+	var override model.UserQuota
+	if err := database.DB.Where("user_id = ?", userID).First(&override).Error; err != nil {
+		return limits
+	}
+	if override.MaxProjects > 0 {
+		limits.MaxProjects = override.MaxProjects
+	}
+	if override.CPU != "" {
+		limits.CPU = override.CPU
+	}
+	if override.Memory != "" {
+		limits.Memory = override.Memory
+	}
+	if override.Storage != "" {
+		limits.Storage = override.Storage
+	}
+	if override.MaxBuilds > 0 {
+		limits.MaxBuilds = override.MaxBuilds
+	}
+	limits.UpdatedAt = override.UpdatedAt
+	return limits
+}
+
+// Resources parses the CPU and memory limits, falling back to the defaults (validated by config.Load) if they are invalid
+func Resources(limits model.UserQuota) (resource.Quantity, resource.Quantity) {
+	cpu, err := resource.ParseQuantity(limits.CPU)
+	if err != nil {
+		cpu = resource.MustParse(config.App.UserCPUQuota)
+	}
+	memory, err := resource.ParseQuantity(limits.Memory)
+	if err != nil {
+		memory = resource.MustParse(config.App.UserMemoryQuota)
+	}
+	return cpu, memory
+}
+
+// Storage parses the persistent volume limit, falling back to the default (validated by config.Load) if it is invalid
+func Storage(limits model.UserQuota) resource.Quantity {
+	storage, err := resource.ParseQuantity(limits.Storage)
+	if err != nil {
+		storage = resource.MustParse(config.App.UserStorageQuota)
+	}
+	return storage
+}
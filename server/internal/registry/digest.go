@@ -0,0 +1,294 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"foundry-server/internal/config"
+)
+
+// Reference is a parsed image reference such as ghcr.io/org/app:1.2
+type Reference struct {
+	Host       string // registry host, e.g. ghcr.io (docker.io for Docker Hub)
+	Repository string // e.g. org/app (library/nginx for official Docker Hub images)
+	Tag        string
+	Digest     string // set when the reference pins a digest (repo@sha256:...)
+}
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+	challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// ParseReference parses and validates an image reference. Missing parts get Docker's defaults
+// (docker.io, library/ for single-segment names, the latest tag).
+func ParseReference(ref string) (Reference, error) {
+	r := Reference{}
+	name := strings.TrimSpace(ref)
+	if name == "" {
+		return r, fmt.Errorf("image is required")
+	}
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		r.Digest = name[i+1:]
+		name = name[:i]
+		if !digestPattern.MatchString(r.Digest) {
+			return r, fmt.Errorf("invalid image digest")
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		r.Tag = name[i+1:]
+		name = name[:i]
+		if !tagPattern.MatchString(r.Tag) {
+			return r, fmt.Errorf("invalid image tag")
+		}
+	}
+
+	// The first segment is a registry host if it looks like one
+	r.Host = "docker.io"
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			r.Host = first
+			name = name[i+1:]
+		}
+	}
+	if r.Host == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if !repositoryPattern.MatchString(name) {
+		return r, fmt.Errorf("invalid image name")
+	}
+	r.Repository = name
+
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// Name is the reference without tag or digest, as used by container runtimes
+func (r Reference) Name() string {
+	return r.Host + "/" + r.Repository
+}
+
+// String returns the full reference; a digest takes precedence over the tag
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Tag
+}
+
+// Credentials authenticate against a private registry. Empty values mean anonymous access.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// httpClient only connects to public addresses: registry hosts and token realms come from
+// users, and must not reach the cluster network or the metadata service. The check runs on
+// the resolved address of every connection, redirects included.
+var httpClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: rejectBlockedAddress}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// rejectBlockedAddress refuses connections to loopback, link-local, private and EgressBlockedCIDRs addresses.
+// IsPrivate also covers fc00::/7 and IPv4-mapped internal addresses.
+func rejectBlockedAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("registry address %s is not an IP", host)
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("registry address %s is not allowed", ip)
+	}
+	for _, cidr := range config.App.EgressBlockedCIDRs {
+		if _, block, err := net.ParseCIDR(cidr); err == nil && block.Contains(ip) {
+			return fmt.Errorf("registry address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Digest resolves the reference's tag to the digest of the manifest it currently points to
+func Digest(ctx context.Context, ref Reference, creds Credentials) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	host := ref.Host
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, ref.Repository, ref.Tag)
+
+	resp, err := manifestRequest(ctx, http.MethodHead, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	// Anonymous access was refused: answer the auth challenge and retry
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth, err := authorize(ctx, resp.Header.Get("WWW-Authenticate"), creds)
+		if err != nil {
+			return "", err
+		}
+		resp, err = manifestRequest(ctx, http.MethodHead, manifestURL, auth)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		// Some registries leave the digest header out of HEAD responses
+		if resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") == "" {
+			return manifestDigest(ctx, manifestURL, auth)
+		}
+	} else if resp.StatusCode == http.StatusOK && resp.Header.Get("Docker-Content-Digest") == "" {
+		return manifestDigest(ctx, manifestURL, "")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), nil
+	case http.StatusNotFound:
+		return "", fmt.Errorf("image %s not found", ref)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("access to %s denied", ref)
+	default:
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, ref)
+	}
+}
+
+func manifestRequest(ctx context.Context, method, manifestURL, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("registry unreachable: %v", err)
+	}
+	return resp, nil
+}
+
+// manifestDigest downloads the manifest and hashes it
+func manifestDigest(ctx context.Context, manifestURL, auth string) (string, error) {
+	resp, err := manifestRequest(ctx, http.MethodGet, manifestURL, auth)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body)), nil
+}
+
+// authorize answers a WWW-Authenticate challenge with a Basic header or a Bearer token
+func authorize(ctx context.Context, challenge string, creds Credentials) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds.Username == "" {
+			return "", fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported registry auth %q", scheme)
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry auth challenge without realm")
+	}
+	// The realm receives the registry credentials
+	if u, err := url.Parse(realm); err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("registry auth realm must be an https:// URL")
+	}
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		query.Set("scope", params["scope"])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("registry auth unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry auth returned %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid registry token response: %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge splits `Bearer realm="...",service="..."` into the scheme and its parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	// Values are quoted and may contain commas (scope="repository:org/app:pull,push")
+	for _, m := range challengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	return scheme, params
+}